@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/hirofumi/railway-stations-in-japan/stations"
+)
+
+// checkpoint records the stations extracted from each block so that an
+// interrupted run can skip the blocks already done.
+type checkpoint struct {
+	path string
+	checkpointSource
+	Blocks map[int64][]checkpointStation `json:"blocks"`
+}
+
+// checkpointSource is what the stations of a checkpoint are extracted from:
+// the dump, as it is when the checkpoint is started, and the -pattern flags.
+type checkpointSource struct {
+	Dump     string    `json:"dump"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Patterns []string  `json:"patterns,omitempty"`
+}
+
+// newCheckpointSource describes the dump file and the patterns, leaving the
+// size and the modification time of the dump unset if it cannot be stat'ed,
+// as for the standard input.
+func newCheckpointSource(dumpFileName string, patterns []string) checkpointSource {
+	src := checkpointSource{Dump: dumpFileName, Patterns: patterns}
+
+	if dumpFileName != "-" {
+		if abs, err := filepath.Abs(dumpFileName); err == nil {
+			src.Dump = abs
+		}
+
+		if fi, err := os.Stat(dumpFileName); err == nil {
+			src.Size, src.ModTime = fi.Size(), fi.ModTime()
+		}
+	}
+
+	return src
+}
+
+func (s checkpointSource) equal(t checkpointSource) bool {
+	return s.Dump == t.Dump && s.Size == t.Size && s.ModTime.Equal(t.ModTime) && slices.Equal(s.Patterns, t.Patterns)
+}
+
+// checkpointStation keeps Article, which Station leaves out of JSON, so that
+// -coords still works for the stations read back.
+type checkpointStation struct {
+	stations.Station
+	Article string `json:"article,omitempty"`
+}
+
+// loadCheckpoint reads the checkpoint in path, or starts one if there is
+// none. It fails if the checkpoint was recorded from another source, whose
+// blocks would not be those of src.
+func loadCheckpoint(path string, src checkpointSource) (*checkpoint, error) {
+	c := checkpoint{path: path, checkpointSource: src, Blocks: make(map[int64][]checkpointStation)}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &c, nil
+		}
+
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	c.checkpointSource = checkpointSource{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint: %w", err)
+	}
+
+	if !c.checkpointSource.equal(src) {
+		return nil, classify(errInput, fmt.Errorf("checkpoint %s was recorded from another dump or with other -pattern flags; remove it to start over", path))
+	}
+
+	return &c, nil
+}
+
+func (c *checkpoint) stations(offset int64) []stations.Station {
+	ss := make([]stations.Station, len(c.Blocks[offset]))
+
+	for i, cs := range c.Blocks[offset] {
+		ss[i] = cs.Station
+		ss[i].Article = cs.Article
+	}
+
+	return ss
+}
+
+func (c *checkpoint) record(offset int64, ss []stations.Station) error {
+	cs := make([]checkpointStation, len(ss))
+	for i, s := range ss {
+		cs[i] = checkpointStation{Station: s, Article: s.Article}
+	}
+
+	c.Blocks[offset] = cs
+
+	return c.save()
+}
+
+// save replaces the checkpoint file atomically so that a crash never leaves
+// it half written.
+func (c *checkpoint) save() error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	err = writeFileAtomic(c.path, 0o644, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// remaining returns index without the blocks already in the checkpoint.
+func (c *checkpoint) remaining(index *stations.Index) *stations.Index {
+	r := stations.Index{
+		BlockSize: index.BlockSize,
+		OnDump:    make(map[int64][]stations.IndexEntry),
+		OnID:      make(map[int64]*stations.IndexEntry),
+		OnTitle:   make(map[string]*stations.IndexEntry),
+	}
+
+	for offset, entries := range index.OnDump {
+		if _, ok := c.Blocks[offset]; ok {
+			continue
+		}
+
+		r.OnDump[offset] = entries
+		for i := range entries {
+			r.OnID[entries[i].ID] = &entries[i]
+			r.OnTitle[entries[i].Title] = &entries[i]
+		}
+	}
+
+	return &r
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hirofumi/railway-stations-in-japan/stations"
+)
+
+// readStations reads stations written as TSV, JSON or NDJSON, telling them
+// apart by the first byte, with or without the schema version. It also
+// returns the TSV columns, or nil for JSON which has every field.
+func (in files) readStations(name string) ([]stations.Station, []string, error) {
+	f, err := in.open(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+
+	first, err := br.Peek(1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	switch {
+	case len(first) == 0:
+		return nil, nil, nil
+	case first[0] == '[':
+		var ss []stations.Station
+		if err := json.NewDecoder(br).Decode(&ss); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode %s: %w", name, err)
+		}
+
+		return ss, nil, nil
+	case first[0] == '{':
+		var ss []stations.Station
+
+		d := json.NewDecoder(br)
+		for {
+			// Either a station of NDJSON or the JSON with the schema version.
+			var v struct {
+				stations.Station
+				Stations []stations.Station `json:"stations"`
+			}
+			if err := d.Decode(&v); err != nil {
+				if errors.Is(err, io.EOF) {
+					return ss, nil, nil
+				}
+
+				return nil, nil, fmt.Errorf("failed to decode %s: %w", name, err)
+			}
+
+			if v.Stations != nil {
+				return v.Stations, nil, nil
+			}
+
+			ss = append(ss, v.Station)
+		}
+	default:
+		ss, columns, err := stations.ReadTSVColumns(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		return ss, columns, nil
+	}
+}
+
+// writeDiff writes the removed stations prefixed with - and then the added
+// ones prefixed with +.
+func writeDiff(w io.Writer, added, removed []stations.Station) error {
+	bw := bufio.NewWriter(w)
+
+	for _, d := range []struct {
+		sign string
+		ss   []stations.Station
+	}{{"-", removed}, {"+", added}} {
+		for _, s := range d.ss {
+			fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", d.sign, s.Name, s.NameKana, s.NameEn, s.Prefecture, s.Operator, s.Line)
+		}
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+func isURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// fetch downloads url into cacheDir and returns the path of the downloaded
+// file. A cached file is reused when it has the ETag or, lacking one, the
+// size the server reports. The file keeps the base name of url so that its
+// extension still selects the decompression.
+func fetch(url, cacheDir string) (string, error) {
+	sum := sha256.Sum256([]byte(url))
+	name := filepath.Join(cacheDir, hex.EncodeToString(sum[:8])+"-"+path.Base(url))
+
+	res, err := http.Head(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to request %s: %w", url, err)
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to request %s: %s", url, res.Status)
+	}
+
+	etag := res.Header.Get("ETag")
+	if cached(name, etag, res.ContentLength) {
+		return name, nil
+	}
+
+	if err := download(url, name); err != nil {
+		return "", err
+	}
+
+	if etag != "" {
+		if err := os.WriteFile(name+".etag", []byte(etag), 0o644); err != nil {
+			return "", fmt.Errorf("failed to write etag: %w", err)
+		}
+	}
+
+	return name, nil
+}
+
+func cached(name, etag string, size int64) bool {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+
+	if etag != "" {
+		b, err := os.ReadFile(name + ".etag")
+		return err == nil && string(b) == etag
+	}
+
+	return size >= 0 && fi.Size() == size
+}
+
+// download writes url to name through a temporary file so that an
+// interrupted download is never mistaken for a cached one.
+func download(url, name string) error {
+	res, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", url, res.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	err = writeFileAtomic(name, 0o644, func(w io.Writer) error {
+		n, err := io.Copy(w, res.Body)
+		if err == nil && res.ContentLength >= 0 && n != res.ContentLength {
+			err = fmt.Errorf("got %d of %d bytes", n, res.ContentLength)
+		}
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+// fixtureServer serves the fixture dump and index, counting the downloads of
+// each.
+func fixtureServer(t *testing.T) (*httptest.Server, map[string]int) {
+	downloads := make(map[string]int)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := map[string]string{"/dump.xml.bz2": fixtureDump, "/index.txt.bz2": fixtureIndex}[r.URL.Path]
+		if name == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			downloads[r.URL.Path]++
+		}
+
+		w.Header().Set("ETag", `"`+path.Base(name)+`"`)
+		http.ServeFile(w, r, name)
+	}))
+	t.Cleanup(s.Close)
+
+	return s, downloads
+}
+
+func TestFetch(t *testing.T) {
+	s, downloads := fixtureServer(t)
+	dir := t.TempDir()
+
+	for i := 0; i < 2; i++ {
+		name, err := fetch(s.URL+"/dump.xml.bz2", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if path.Ext(name) != ".bz2" {
+			t.Errorf("got %s, want the extension kept", name)
+		}
+
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := os.ReadFile(fixtureDump)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(got) != string(want) {
+			t.Errorf("got %d bytes, want the %d of the fixture", len(got), len(want))
+		}
+	}
+
+	if n := downloads["/dump.xml.bz2"]; n != 1 {
+		t.Errorf("got %d downloads, want 1 with the cached file reused", n)
+	}
+
+	if _, err := fetch(s.URL+"/missing.xml", dir); err == nil {
+		t.Error("got no error for a missing file")
+	}
+}
+
+func TestURLFlags(t *testing.T) {
+	s, _ := fixtureServer(t)
+
+	want, _, err := runMain(t, "-d", fixtureDump, "-i", fixtureIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := runMain(t, "-d", s.URL+"/dump.xml.bz2", "-i", s.URL+"/index.txt.bz2", "-cache-dir", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/hirofumi/railway-stations-in-japan/stations"
+)
+
+// indexCache is an index saved by -index-cache along with what it was
+// extracted from, so that it is only reused for the same index file and the
+// same selection of titles.
+type indexCache struct {
+	Source          string                    `json:"source"`
+	Size            int64                     `json:"size"`
+	ModTime         time.Time                 `json:"mod_time"`
+	Titles          string                    `json:"titles"`
+	BlockSize       map[int64]int64           `json:"block_sizes"`
+	Entries         []stations.IndexEntry     `json:"entries"`
+	DuplicateTitles []stations.DuplicateTitle `json:"duplicate_titles,omitempty"`
+
+	newer bool
+}
+
+// cachedIndex returns the index cached in path if it is newer than the index
+// file and was extracted from it, as it is now, with the same titles, a
+// description of the titles selected. Otherwise it extracts the index with
+// extract and caches it.
+func cachedIndex(path, indexFileName, titles string, extract func() (*stations.Index, error)) (*stations.Index, error) {
+	fi, err := os.Stat(indexFileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat index file: %w", classify(errInput, err))
+	}
+
+	source, err := filepath.Abs(indexFileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve index file: %w", classify(errInput, err))
+	}
+
+	want := indexCache{Source: source, Size: fi.Size(), ModTime: fi.ModTime(), Titles: titles}
+
+	c, err := loadIndexCache(path, fi.ModTime())
+	if err != nil {
+		return nil, err
+	}
+
+	if c != nil && c.newer && c.Source == want.Source && c.Size == want.Size && c.ModTime.Equal(want.ModTime) && c.Titles == want.Titles {
+		return c.index(), nil
+	}
+
+	index, err := extract()
+	if err != nil {
+		return nil, err
+	}
+
+	want.BlockSize = index.BlockSize
+	want.DuplicateTitles = index.DuplicateTitles
+
+	offsets := make([]int64, 0, len(index.OnDump))
+	for offset := range index.OnDump {
+		offsets = append(offsets, offset)
+	}
+
+	slices.Sort(offsets)
+
+	for _, offset := range offsets {
+		want.Entries = append(want.Entries, index.OnDump[offset]...)
+	}
+
+	if err := want.save(path); err != nil {
+		return nil, classify(errWrite, err)
+	}
+
+	return index, nil
+}
+
+// loadIndexCache returns nil if there is no cache in path yet, or it is
+// corrupt and has to be rebuilt anyway.
+func loadIndexCache(path string, since time.Time) (*indexCache, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to stat index cache: %w", classify(errInput, err))
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index cache: %w", classify(errInput, err))
+	}
+
+	var c indexCache
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, nil
+	}
+
+	c.newer = fi.ModTime().After(since)
+
+	return &c, nil
+}
+
+func (c *indexCache) index() *stations.Index {
+	index := stations.Index{
+		BlockSize:       c.BlockSize,
+		OnDump:          make(map[int64][]stations.IndexEntry),
+		OnID:            make(map[int64]*stations.IndexEntry),
+		OnTitle:         make(map[string]*stations.IndexEntry),
+		DuplicateTitles: c.DuplicateTitles,
+	}
+
+	for _, e := range c.Entries {
+		index.OnDump[e.Offset] = append(index.OnDump[e.Offset], e)
+	}
+
+	// The entries are pointed to once OnDump is done growing, in the order
+	// listed so that OnTitle has the last entry of each title.
+	n := make(map[int64]int)
+	for _, e := range c.Entries {
+		p := &index.OnDump[e.Offset][n[e.Offset]]
+		n[e.Offset]++
+		index.OnID[p.ID] = p
+		index.OnTitle[p.Title] = p
+	}
+
+	return &index
+}
+
+// save replaces the cache file atomically so that a crash never leaves it
+// half written.
+func (c *indexCache) save(path string) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode index cache: %w", err)
+	}
+
+	err = writeFileAtomic(path, 0o644, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write index cache: %w", err)
+	}
+
+	return nil
+}
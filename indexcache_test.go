@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hirofumi/railway-stations-in-japan/stations"
+)
+
+func TestCachedIndex(t *testing.T) {
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, "index.txt")
+	cache := filepath.Join(dir, "index.json")
+
+	if err := os.WriteFile(indexFile, []byte("0:1:A\n0:2:B\n10:3:C\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index file is older than the cache saved from it.
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(indexFile, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	extracted := 0
+	extract := func() (*stations.Index, error) {
+		extracted++
+
+		f, err := os.Open(indexFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return stations.ExtractIndex(f, func([]byte) bool { return true })
+	}
+
+	for _, tt := range []struct {
+		name      string
+		titles    string
+		touch     bool
+		extracted int
+	}{
+		{"created", "all", false, 1},
+		{"reused", "all", false, 1},
+		{"other titles", "some", false, 2},
+		{"index changed", "some", true, 3},
+		{"reused again", "some", false, 3},
+	} {
+		if tt.touch {
+			future := time.Now().Add(time.Hour)
+			if err := os.Chtimes(indexFile, future, future); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		index, err := cachedIndex(cache, indexFile, tt.titles, extract)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if extracted != tt.extracted {
+			t.Errorf("%s: extracted %d times, want %d", tt.name, extracted, tt.extracted)
+		}
+
+		if len(index.OnDump[0]) != 2 || index.OnTitle["C"] == nil || index.OnID[2].Title != "B" {
+			t.Errorf("%s: got %+v", tt.name, index)
+		}
+
+		if tt.touch {
+			// The cache saved now is older than the index file touched into
+			// the future, so make it newer again.
+			later := time.Now().Add(2 * time.Hour)
+			if err := os.Chtimes(cache, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
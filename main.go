@@ -3,262 +3,1580 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"compress/bzip2"
-	"encoding/csv"
-	"encoding/xml"
+	"compress/gzip"
+	"context"
+	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"math"
+	"log/slog"
+	"math/rand"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
-	"strconv"
 	"strings"
+	"time"
+
+	"github.com/hirofumi/railway-stations-in-japan/stations"
+	_ "modernc.org/sqlite"
 )
 
-const listPagePrefix = "List of railway stations in Japan: "
+var (
+	errInput = errors.New("input error")
+	errParse = errors.New("parse error")
+	errWrite = errors.New("write error")
+	// errInterrupted is returned after writing what was extracted before an
+	// interrupt, along with the error of the context.
+	errInterrupted = errors.New("interrupted")
+)
 
-type Index struct {
-	BlockSize map[int64]int64
-	OnDump    map[int64][]IndexEntry
-	OnID      map[int64]*IndexEntry
-	OnTitle   map[string]*IndexEntry
+// classifiedError attaches one of the sentinel errors above to err without
+// changing its message.
+type classifiedError struct {
+	class error
+	err   error
 }
 
-type IndexEntry struct {
-	ID     int64
-	Title  string
-	Offset int64
+func (e *classifiedError) Error() string {
+	return e.err.Error()
 }
 
-type Block struct {
-	Pages []Page `xml:"page"`
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.class, e.err}
 }
 
-type Page struct {
-	ID       int64    `xml:"id"`
-	Title    string   `xml:"title"`
-	Revision Revision `xml:"revision"`
-}
+// classify attaches class to err unless err is already classified.
+func classify(class, err error) error {
+	var ce *classifiedError
+	if err == nil || errors.As(err, &ce) {
+		return err
+	}
 
-type Revision struct {
-	Text string `xml:"text"`
+	return &classifiedError{class: class, err: err}
 }
 
-type Station struct {
-	Name     string `json:"name"`
-	NameKana string `json:"name_kana"`
-	NameEn   string `json:"name_en"`
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errInput):
+		return 2
+	case errors.Is(err, errParse):
+		return 3
+	case errors.Is(err, errWrite):
+		return 4
+	case errors.Is(err, errInterrupted):
+		return 130
+	default:
+		return 1
+	}
 }
 
 func main() {
-	if err := run(); err != nil {
-		panic(err)
-	}
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 
-func run() error {
-	var (
-		dumpFileName  = flag.String("d", "enwiki-20210920-pages-articles-multistream.xml.bz2", "dump file")
-		indexFileName = flag.String("i", "enwiki-20210920-pages-articles-multistream-index.txt.bz2", "index file")
-	)
-	flag.Parse()
+	opts := DefaultOptions()
 
-	index, err := extractIndex(*indexFileName, func(title []byte) bool { return bytes.HasPrefix(title, []byte(listPagePrefix)) })
-	if err != nil {
-		return fmt.Errorf("failed to extract index: %w", err)
+	// The flag package exits with 2 on a usage error, which is the code of
+	// errInput, so the usage errors exit with 1 here instead.
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	opts.flags(fs)
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(1)
 	}
 
-	pages, err := extractPages(*dumpFileName, index)
+	err := RunContext(ctx, opts)
+
+	stop()
+
 	if err != nil {
-		return fmt.Errorf("failed to extract pages: %w", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
 	}
+}
 
-	err = writeTSV(os.Stdout, uniquify(removeDisambiguations(extractStations(pages))))
-	if err != nil {
-		return fmt.Errorf("failed to write TSV: %w", err)
+// Options configures RunContext. Each field is the flag named in its comment;
+// DefaultOptions has the defaults of the flags.
+type Options struct {
+	Date                string        // -date
+	Lang                string        // -lang
+	Dump                string        // -d
+	Index               string        // -i
+	Format              string        // -format
+	Stream              bool          // -stream
+	Coords              bool          // -coords
+	Since               int           // -since
+	SinceStrict         bool          // -since-strict
+	Limit               int           // -limit
+	Gzip                bool          // -gzip
+	Shuffle             bool          // -shuffle
+	Seed                int64         // -seed
+	Jobs                int           // -jobs
+	Progress            bool          // -progress
+	Count               bool          // -count
+	MaxNameLength       int           // -max-name-length
+	LogLevel            string        // -log-level
+	Verbose             bool          // -verbose
+	Mmap                bool          // -mmap
+	Validate            bool          // -validate
+	KeepGoing           bool          // -keep-going
+	MinStations         int           // -min-stations
+	NormalizeKana       bool          // -normalize-kana
+	StrictKana          bool          // -strict-kana
+	Checkpoint          string        // -checkpoint
+	Sort                string        // -sort
+	Provenance          bool          // -provenance
+	FoldCase            bool          // -fold-case
+	NormalizeSuffix     bool          // -normalize-suffix
+	CacheDir            string        // -cache-dir
+	IndexOnly           bool          // -index-only
+	Retries             int           // -retries
+	RetryDelay          time.Duration // -retry-delay
+	TitleRegexp         string        // -title-regexp
+	Missing             string        // -missing
+	JSONOmitEmpty       bool          // -json-omitempty
+	DedupKey            string        // -dedup-key
+	Diff                string        // -diff
+	FailIfEmpty         bool          // -fail-if-empty
+	MinRows             int           // -min-rows
+	WithID              bool          // -with-id
+	ExcludeRegexp       string        // -exclude-regexp
+	ExcludeField        string        // -exclude-field
+	Pretty              bool          // -pretty
+	PrettyForce         bool          // -pretty-force
+	Wikidata            bool          // -wikidata
+	WikidataDump        string        // -wikidata-dump
+	HeaderMap           string        // -header-map
+	PageTimeout         time.Duration // -page-timeout
+	DupStats            int           // -dup-stats
+	SchemaVersion       bool          // -schema-version
+	FailOnMissing       bool          // -fail-on-missing
+	Romanize            bool          // -romanize
+	RomanizeMacrons     bool          // -romanize-macrons
+	KeepDisambiguation  bool          // -keep-disambiguation
+	OnlyAdded           bool          // -only-added
+	WithRaw             bool          // -with-raw
+	Strict              bool          // -strict
+	CountBy             string        // -count-by
+	CountByAndRows      bool          // -count-by-and-rows
+	ReadBuffer          int           // -read-buffer
+	MaxIndexLine        int           // -max-index-line
+	DumpMatchedTitles   string        // -dump-matched-titles
+	NormalizeWhitespace bool          // -normalize-whitespace
+	OnlyTitle           string        // -only-title
+	RejectFile          string        // -reject-file
+	SingleStream        bool          // -single-stream
+	MetricsFile         string        // -metrics-file
+	Disambig            string        // -disambig
+	DisambigRegexp      string        // -disambig-regexp
+	NoDisambig          bool          // -no-disambig
+	NullGeometry        bool          // -null-geometry
+	StrictUTF8          string        // -strict-utf8
+	IndexCache          string        // -index-cache
+	Columns             string        // -columns
+	Patterns            []string      // -pattern, once per occurrence
+	TitlePrefixes       []string      // -title-prefix, once per occurrence
+	Prefectures         []string      // -prefecture, once per occurrence
+	Output              string        // -o and -output
+
+	// Stdin, Stdout and Stderr replace os.Stdin, os.Stdout and os.Stderr
+	// unless nil.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// DefaultOptions returns the options of a run without flags.
+func DefaultOptions() Options {
+	return Options{
+		Date:            "20210920",
+		Lang:            "en",
+		Format:          "tsv",
+		Seed:            1,
+		Jobs:            runtime.GOMAXPROCS(0),
+		MaxNameLength:   64,
+		LogLevel:        "warn",
+		Sort:            "en",
+		CacheDir:        filepath.Join(os.TempDir(), "railway-stations-in-japan"),
+		RetryDelay:      time.Second,
+		ExcludeField:    "name_en",
+		RomanizeMacrons: true,
+		ReadBuffer:      64 << 10,
+		MaxIndexLine:    1 << 20,
 	}
+}
 
-	return nil
+// flags defines the flags setting o on fs, defaulting to its values.
+func (o *Options) flags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Date, "date", o.Date, "dump date used to construct the default file names")
+	fs.StringVar(&o.Lang, "lang", o.Lang, "wiki language used to construct the default file names")
+	fs.StringVar(&o.Dump, "d", o.Dump, "dump file, or comma-separated dump files split from one dump (default {lang}wiki-{date}-pages-articles-multistream.xml.bz2)")
+	fs.StringVar(&o.Index, "i", o.Index, "index file, or comma-separated index files paired with -d; optional with -single-stream (default {lang}wiki-{date}-pages-articles-multistream-index.txt.bz2)")
+	fs.StringVar(&o.Format, "format", o.Format, "comma-separated output formats (tsv, json, ndjson, geojson or sqlite); several need -o with {ext}")
+	fs.BoolVar(&o.Stream, "stream", o.Stream, "read the dump sequentially instead of seeking (allows - for stdin)")
+	fs.BoolVar(&o.Coords, "coords", o.Coords, "resolve coordinates from station articles (slow)")
+	fs.IntVar(&o.Since, "since", o.Since, "keep only stations opened in this year or later, read from station articles (slow)")
+	fs.BoolVar(&o.SinceStrict, "since-strict", o.SinceStrict, "make -since also drop stations whose opening year is unknown")
+	fs.IntVar(&o.Limit, "limit", o.Limit, "maximum number of stations to output (0 means no limit)")
+	fs.BoolVar(&o.Gzip, "gzip", o.Gzip, "gzip the output")
+	fs.BoolVar(&o.Shuffle, "shuffle", o.Shuffle, "make -limit pick a random sample instead of the first stations")
+	fs.Int64Var(&o.Seed, "seed", o.Seed, "random seed for -shuffle")
+	fs.IntVar(&o.Jobs, "jobs", o.Jobs, "number of blocks decompressed and decoded concurrently; 1 decodes them one after another")
+	fs.BoolVar(&o.Progress, "progress", o.Progress, "report progress to stderr")
+	fs.BoolVar(&o.Count, "count", o.Count, "report counts to stderr instead of writing stations")
+	fs.IntVar(&o.MaxNameLength, "max-name-length", o.MaxNameLength, "drop stations whose English name is longer than this (0 means no limit)")
+	fs.StringVar(&o.LogLevel, "log-level", o.LogLevel, "level of the logs written to stderr (debug, info, warn or error)")
+	fs.BoolVar(&o.Verbose, "verbose", o.Verbose, "report dropped and suspicious stations to stderr")
+	fs.BoolVar(&o.Mmap, "mmap", o.Mmap, "memory-map the dump instead of reading it (ignored with -stream)")
+	fs.BoolVar(&o.Validate, "validate", o.Validate, "report the numbers of valid and invalid stations by reason to stderr")
+	fs.BoolVar(&o.KeepGoing, "keep-going", o.KeepGoing, "skip the blocks failing to decode, reporting them at the end (not with -stream)")
+	fs.IntVar(&o.MinStations, "min-stations", o.MinStations, "drop the stations of list pages yielding fewer than this")
+	fs.BoolVar(&o.NormalizeKana, "normalize-kana", o.NormalizeKana, "widen half-width katakana in kana")
+	fs.BoolVar(&o.StrictKana, "strict-kana", o.StrictKana, "drop stations whose kana has non-kana characters")
+	fs.StringVar(&o.Checkpoint, "checkpoint", o.Checkpoint, "file recording finished blocks so that a rerun on the same dump with the same -pattern can resume")
+	fs.StringVar(&o.Sort, "sort", o.Sort, "output order (en, kana, prefecture or none for the order found)")
+	fs.BoolVar(&o.Provenance, "provenance", o.Provenance, "include the list page each station came from")
+	fs.BoolVar(&o.FoldCase, "fold-case", o.FoldCase, "deduplicate English names case insensitively")
+	fs.BoolVar(&o.NormalizeSuffix, "normalize-suffix", o.NormalizeSuffix, "deduplicate English names ignoring a trailing \" Station\", keeping the name without it")
+	fs.StringVar(&o.CacheDir, "cache-dir", o.CacheDir, "directory caching the files downloaded for -d and -i URLs")
+	fs.BoolVar(&o.IndexOnly, "index-only", o.IndexOnly, "print the matching index entries as TSV instead of reading the dump")
+	fs.IntVar(&o.Retries, "retries", o.Retries, "number of times to retry opening the index and the dump files on transient errors")
+	fs.DurationVar(&o.RetryDelay, "retry-delay", o.RetryDelay, "delay before the first retry, doubled for each following one")
+	fs.StringVar(&o.TitleRegexp, "title-regexp", o.TitleRegexp, "select the list pages whose title matches this regexp")
+	fs.StringVar(&o.Missing, "missing", o.Missing, "placeholder written for empty fields")
+	fs.BoolVar(&o.JSONOmitEmpty, "json-omitempty", o.JSONOmitEmpty, "leave empty fields out of JSON")
+	fs.StringVar(&o.DedupKey, "dedup-key", o.DedupKey, "comma-separated fields that make stations the same (default all)")
+	fs.StringVar(&o.Diff, "diff", o.Diff, "print the stations removed from (-) and added to (+) this earlier TSV, JSON or NDJSON output instead of writing them")
+	fs.BoolVar(&o.FailIfEmpty, "fail-if-empty", o.FailIfEmpty, "fail without writing if no station is found")
+	fs.IntVar(&o.MinRows, "min-rows", o.MinRows, "fail without writing if fewer stations than this are found")
+	fs.BoolVar(&o.WithID, "with-id", o.WithID, "add a stable id derived from the names, prefecture, operator, line, code and disambiguation as compared in deduplication, see -dedup-key, as the first column")
+	fs.StringVar(&o.ExcludeRegexp, "exclude-regexp", o.ExcludeRegexp, "drop stations whose -exclude-field matches this regexp")
+	fs.StringVar(&o.ExcludeField, "exclude-field", o.ExcludeField, "column matched by -exclude-regexp")
+	fs.BoolVar(&o.Pretty, "pretty", o.Pretty, "align the TSV columns for reading when writing to a terminal")
+	fs.BoolVar(&o.PrettyForce, "pretty-force", o.PrettyForce, "align the TSV columns for reading even when not writing to a terminal")
+	fs.BoolVar(&o.Wikidata, "wikidata", o.Wikidata, "resolve the Wikidata IDs of the stations from -wikidata-dump")
+	fs.StringVar(&o.WikidataDump, "wikidata-dump", o.WikidataDump, "file of tab-separated Wikidata item ID, site and title, as in the wb_items_per_site table")
+	fs.StringVar(&o.HeaderMap, "header-map", o.HeaderMap, "comma-separated column=label pairs renaming the TSV header")
+	fs.DurationVar(&o.PageTimeout, "page-timeout", o.PageTimeout, "skip list pages taking longer than this to match (0 means no limit)")
+	fs.IntVar(&o.DupStats, "dup-stats", o.DupStats, "report the numbers of stations before and after deduplication and this many of the most duplicated to stderr")
+	fs.BoolVar(&o.SchemaVersion, "schema-version", o.SchemaVersion, "wrap JSON in an object with the schema version and the dump date, and start TSV with a comment line carrying them")
+	fs.BoolVar(&o.FailOnMissing, "fail-on-missing", o.FailOnMissing, "fail if a block of the dump lacks a page its index entries point to, as with an index of another dump")
+	fs.BoolVar(&o.Romanize, "romanize", o.Romanize, "fill in the missing English names by romanizing the kana")
+	fs.BoolVar(&o.RomanizeMacrons, "romanize-macrons", o.RomanizeMacrons, "write the long vowels romanized by -romanize with macrons (ō) rather than two letters (ou)")
+	fs.BoolVar(&o.KeepDisambiguation, "keep-disambiguation", o.KeepDisambiguation, "keep the parenthetical removed from the names, such as (Tokyo), in a disambiguation column and tell stations apart by it")
+	fs.BoolVar(&o.OnlyAdded, "only-added", o.OnlyAdded, "with -diff, write the stations not in the earlier output in the usual format instead of the differences")
+	fs.BoolVar(&o.WithRaw, "with-raw", o.WithRaw, "include the wikitext each station was extracted from in the output, for debugging the patterns")
+	fs.BoolVar(&o.Strict, "strict", o.Strict, "fail if the index lists a title for two pages")
+	fs.StringVar(&o.CountBy, "count-by", o.CountBy, "report the number of stations by each value of this column to stderr instead of writing stations")
+	fs.BoolVar(&o.CountByAndRows, "count-by-and-rows", o.CountByAndRows, "write the stations as well with -count-by")
+	fs.IntVar(&o.ReadBuffer, "read-buffer", o.ReadBuffer, "size in bytes of the buffer reading the index files")
+	fs.IntVar(&o.MaxIndexLine, "max-index-line", o.MaxIndexLine, "length in bytes of the longest index line allowed")
+	fs.StringVar(&o.DumpMatchedTitles, "dump-matched-titles", o.DumpMatchedTitles, "write the sorted titles of the list pages matched in the index to this file, or to stderr if -")
+	fs.BoolVar(&o.NormalizeWhitespace, "normalize-whitespace", o.NormalizeWhitespace, "collapse the runs of whitespace in the names and cells, tabs and no-break spaces included, to a single space")
+	fs.StringVar(&o.OnlyTitle, "only-title", o.OnlyTitle, "extract only the page of this exact title instead of the list pages, decoding just its block")
+	fs.StringVar(&o.RejectFile, "reject-file", o.RejectFile, "write the stations failing validation to this TSV file with a reason column instead of the output")
+	fs.BoolVar(&o.SingleStream, "single-stream", o.SingleStream, "read a dump that is not multistream, such as {lang}wiki-{date}-pages-articles.xml.bz2, scanning it as -stream does")
+	fs.StringVar(&o.MetricsFile, "metrics-file", o.MetricsFile, "write the numbers of the run to this file for the Prometheus textfile collector")
+	fs.StringVar(&o.Disambig, "disambig", o.Disambig, "disambiguation stripped from the names: first, the default, for everything from the first parenthesis, or trailing for only a parenthetical ending the name")
+	fs.StringVar(&o.DisambigRegexp, "disambig-regexp", o.DisambigRegexp, "regexp matching the disambiguation stripped from the names instead of -disambig, whose first group -keep-disambiguation keeps")
+	fs.BoolVar(&o.NoDisambig, "no-disambig", o.NoDisambig, "keep the disambiguations in the names")
+	fs.BoolVar(&o.NullGeometry, "null-geometry", o.NullGeometry, "keep the stations without coordinates in GeoJSON as features without a geometry")
+	fs.StringVar(&o.StrictUTF8, "strict-utf8", o.StrictUTF8, "drop (with drop) or fail on (with error) the stations with invalid UTF-8 instead of replacing it with U+FFFD")
+	fs.StringVar(&o.IndexCache, "index-cache", o.IndexCache, "file caching the extracted index, reused while newer than the index file")
+	fs.StringVar(&o.Columns, "columns", o.Columns, "comma-separated columns to write in order (default name, name_kana, name_en, prefecture, operator and line, with lat and lon for -coords)")
+	fs.StringVar(&o.Output, "o", o.Output, "output file, where {ext} is replaced with the format (default stdout)")
+	fs.StringVar(&o.Output, "output", o.Output, "output file, where {ext} is replaced with the format (default stdout)")
+	fs.Var((*stringList)(&o.Patterns), "pattern", "regexp matching a station row with groups for English name, Japanese name and kana; may be repeated to try several in order (default built-in)")
+	fs.Var((*stringList)(&o.TitlePrefixes), "title-prefix", "select the list pages whose title starts with this; may be repeated (default \""+stations.ListPagePrefix+"\" unless -title-regexp is given)")
+	fs.Var((*stringList)(&o.Prefectures), "prefecture", "keep only stations in these comma-separated prefectures; may be repeated")
 }
 
-func extractIndex(indexFileName string, shouldIndex func([]byte) bool) (*Index, error) {
-	f, err := os.Open(indexFileName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open index file: %w", err)
+// RunContext extracts the stations as the command does with opts. It stops
+// reading the dump once ctx is done and writes the stations found so far.
+func RunContext(ctx context.Context, opts Options) error {
+	stdin, stdout, stderr := io.Reader(os.Stdin), io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if opts.Stdin != nil {
+		stdin = opts.Stdin
+	}
+	if opts.Stdout != nil {
+		stdout = opts.Stdout
+	}
+	if opts.Stderr != nil {
+		stderr = opts.Stderr
 	}
 
-	defer f.Close()
+	// A single stream dump has no offsets to seek to, but its pages are
+	// those of the multistream index of the same date.
+	if opts.SingleStream {
+		opts.Stream = true
+	}
+
+	if opts.Dump == "" && opts.SingleStream {
+		opts.Dump = fmt.Sprintf("%swiki-%s-pages-articles.xml.bz2", opts.Lang, opts.Date)
+	}
+	if opts.Dump == "" {
+		opts.Dump = fmt.Sprintf("%swiki-%s-pages-articles-multistream.xml.bz2", opts.Lang, opts.Date)
+	}
+	// Without an index, a single stream dump is matched by the titles.
+	noIndex := opts.Index == "" && opts.SingleStream
 
-	index := Index{
-		BlockSize: make(map[int64]int64),
-		OnDump:    make(map[int64][]IndexEntry),
-		OnID:      make(map[int64]*IndexEntry),
-		OnTitle:   make(map[string]*IndexEntry),
+	if opts.Index == "" {
+		opts.Index = fmt.Sprintf("%swiki-%s-pages-articles-multistream-index.txt.bz2", opts.Lang, opts.Date)
 	}
 
-	last := int64(math.MaxInt64)
+	dumpFileNames := (&stringList{opts.Dump}).split()
+	indexFileNames := (&stringList{opts.Index}).split()
+	if noIndex {
+		indexFileNames = make([]string, len(dumpFileNames))
+	}
 
-	r := bufio.NewReader(bzip2.NewReader(f))
+	if len(dumpFileNames) != len(indexFileNames) {
+		return fmt.Errorf("-d has %d files but -i has %d", len(dumpFileNames), len(indexFileNames))
+	}
 
-	for {
-		line, _, err := r.ReadLine()
+	for _, names := range [][]string{dumpFileNames, indexFileNames} {
+		for i, name := range names {
+			if isURL(name) {
+				local, err := fetch(name, opts.CacheDir)
+				if err != nil {
+					return classify(errInput, err)
+				}
+
+				names[i] = local
+			}
+		}
+	}
+
+	dumps := make([]dumpFiles, len(dumpFileNames))
+	for i := range dumps {
+		dumps[i] = dumpFiles{dump: dumpFileNames[i], index: indexFileNames[i]}
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(opts.LogLevel)); err != nil {
+		return fmt.Errorf("invalid -log-level %q: %w", opts.LogLevel, err)
+	}
+
+	logger := slog.New(newLogHandler(stderr, &slog.HandlerOptions{Level: level}))
+
+	m := metrics{start: time.Now()}
+	if opts.MetricsFile != "" {
+		defer func() {
+			if err := m.write(opts.MetricsFile); err != nil {
+				logger.Error("failed to write metrics", "error", err)
+			}
+		}()
+	}
+
+	in := files{
+		open:  retrying(os.Open, opts.Retries, opts.RetryDelay, stderr),
+		stdin: stdin,
+		index: stations.IndexOptions{BufferSize: opts.ReadBuffer, MaxLineSize: opts.MaxIndexLine},
+	}
+
+	output := stations.OutputOptions{Provenance: opts.Provenance, Coordinates: opts.Coords, Missing: opts.Missing, OmitEmpty: opts.JSONOmitEmpty, WithID: opts.WithID, Disambiguation: opts.KeepDisambiguation, Raw: opts.WithRaw, NullGeometry: opts.NullGeometry, Schema: opts.SchemaVersion, GeneratedFrom: dumpDate(dumps[0].dump, opts.Date)}
+
+	if opts.Columns != "" {
+		cols := stringList{opts.Columns}
+		output.Columns = cols.split()
+
+		if err := stations.CheckColumns(output.Columns); err != nil {
+			return fmt.Errorf("invalid -columns: %w", err)
+		}
+	}
+
+	for _, pair := range (&stringList{opts.HeaderMap}).split() {
+		name, label, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid -header-map %q: want column=label", pair)
+		}
+
+		name = strings.TrimSpace(name)
+		if err := stations.CheckColumns([]string{name}); err != nil {
+			return fmt.Errorf("invalid -header-map: %w", err)
+		}
+
+		if output.Headers == nil {
+			output.Headers = make(map[string]string)
+		}
+
+		output.Headers[name] = strings.TrimSpace(label)
+	}
+
+	if err := stations.CheckDedupFields((&stringList{opts.DedupKey}).split()); err != nil {
+		return fmt.Errorf("invalid -dedup-key: %w", err)
+	}
+
+	dedup := stations.DedupOptions{FoldCase: opts.FoldCase, TrimStationSuffix: opts.NormalizeSuffix, Disambiguation: opts.KeepDisambiguation}
+	if opts.DedupKey != "" {
+		dedup.Fields = (&stringList{opts.DedupKey}).split()
+	}
+
+	output.Dedup = dedup
+
+	formats := (&stringList{opts.Format}).split()
+	if len(formats) > 1 && !strings.Contains(opts.Output, "{ext}") {
+		return errors.New("several formats require -o with {ext}")
+	}
+
+	var targets []target
+	for _, f := range formats {
+		o := target{format: f, name: strings.ReplaceAll(opts.Output, "{ext}", f)}
+
+		switch f {
+		case "tsv":
+			o.write = output.WriteTSV
+			if opts.PrettyForce || opts.Pretty && (o.name == "" || o.name == "-") && isTerminal(stdout) {
+				o.write = output.WriteTable
+			}
+		case "json":
+			o.write = output.WriteJSON
+		case "ndjson":
+			o.write = output.WriteNDJSON
+		case "geojson":
+			if !opts.Coords {
+				return errors.New("geojson format requires -coords")
+			}
+			o.write = output.WriteGeoJSON
+		case "sqlite":
+			if o.name == "" || o.name == "-" {
+				return errors.New("sqlite format requires -o")
+			}
+			if output.Columns != nil {
+				return errors.New("-columns cannot be used with the sqlite format")
+			}
+			if opts.Gzip {
+				return errors.New("-gzip cannot be used with the sqlite format")
+			}
+		default:
+			return fmt.Errorf("unknown format: %q", f)
+		}
+
+		if opts.Gzip && o.write != nil {
+			o.write = gzipped(o.write)
+		}
+
+		targets = append(targets, o)
+	}
+
+	switch opts.StrictUTF8 {
+	case "", "drop", "error":
+	default:
+		return fmt.Errorf("unknown -strict-utf8 mode: %q", opts.StrictUTF8)
+	}
+
+	switch opts.Sort {
+	case "en", "kana", "prefecture", "none":
+	default:
+		return fmt.Errorf("unknown sort order: %q", opts.Sort)
+	}
+
+	var patterns []*stations.Pattern
+	for _, expr := range opts.Patterns {
+		p, err := stations.CompilePattern(expr)
+		if err != nil {
+			return fmt.Errorf("invalid -pattern %q: %w", expr, err)
+		}
+
+		patterns = append(patterns, p)
+	}
+
+	if len(opts.TitlePrefixes) == 0 && opts.TitleRegexp == "" {
+		opts.TitlePrefixes = stringList{stations.ListPagePrefix}
+	}
+
+	var titleRx *regexp.Regexp
+	if opts.TitleRegexp != "" {
+		rx, err := regexp.Compile(opts.TitleRegexp)
+		if err != nil {
+			return fmt.Errorf("invalid -title-regexp %q: %w", opts.TitleRegexp, err)
+		}
+
+		titleRx = rx
+	}
+
+	var excludeRx *regexp.Regexp
+	if opts.ExcludeRegexp != "" {
+		rx, err := regexp.Compile(opts.ExcludeRegexp)
+		if err != nil {
+			return fmt.Errorf("invalid -exclude-regexp %q: %w", opts.ExcludeRegexp, err)
+		}
+
+		if err := stations.CheckColumns([]string{opts.ExcludeField}); err != nil {
+			return fmt.Errorf("invalid -exclude-field: %w", err)
+		}
+
+		excludeRx = rx
+	}
+
+	var disambigRx *regexp.Regexp
+	// An empty -disambig is first, unless -disambig-regexp replaces it.
+	switch opts.Disambig {
+	case "", "first":
+		disambigRx = stations.DisambiguationRegexp
+	case "trailing":
+		disambigRx = stations.TrailingDisambiguationRegexp
+	default:
+		return fmt.Errorf("unknown -disambig mode: %q", opts.Disambig)
+	}
+
+	if opts.DisambigRegexp != "" {
+		if opts.Disambig != "" {
+			return errors.New("-disambig cannot be used with -disambig-regexp")
+		}
+
+		rx, err := regexp.Compile(opts.DisambigRegexp)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+			return fmt.Errorf("invalid -disambig-regexp: %w", err)
+		}
+
+		disambigRx = rx
+	}
+
+	if opts.NoDisambig && opts.KeepDisambiguation {
+		return errors.New("-no-disambig cannot be used with -keep-disambiguation")
+	}
+
+	isListPage := func(title []byte) bool {
+		for _, prefix := range opts.TitlePrefixes {
+			if bytes.HasPrefix(title, []byte(prefix)) {
+				return true
 			}
+		}
+
+		return titleRx != nil && titleRx.Match(title)
+	}
+
+	if opts.OnlyTitle != "" {
+		isListPage = func(title []byte) bool { return string(title) == opts.OnlyTitle }
+	}
+
+	if slices.Contains(dumpFileNames, "-") {
+		if opts.Coords || opts.Since > 0 {
+			return errors.New("-coords and -since cannot read the dump from stdin")
+		}
+
+		if len(dumps) > 1 {
+			return errors.New("only a single dump can be read from stdin")
+		}
+	}
+
+	if opts.Checkpoint != "" && len(dumps) > 1 {
+		return errors.New("-checkpoint cannot be used with several dumps")
+	}
+
+	if noIndex && (opts.Checkpoint != "" || opts.IndexOnly || opts.DumpMatchedTitles != "") {
+		return errors.New("-checkpoint, -index-only and -dump-matched-titles require -i")
+	}
+
+	if opts.IndexCache != "" && (len(dumps) > 1 || noIndex || dumps[0].index == "-") {
+		return errors.New("-index-cache requires a single index file other than stdin")
+	}
+
+	if opts.Wikidata && opts.WikidataDump == "" {
+		return errors.New("-wikidata requires -wikidata-dump")
+	}
+
+	if opts.KeepGoing && opts.Stream {
+		return errors.New("-keep-going cannot be used with -stream")
+	}
+
+	if opts.CountBy != "" {
+		if err := stations.CheckColumns([]string{opts.CountBy}); err != nil {
+			return fmt.Errorf("invalid -count-by: %w", err)
+		}
+	}
+
+	if opts.OnlyAdded && opts.Diff == "" {
+		return errors.New("-only-added requires -diff")
+	}
+
+	if opts.FailOnMissing && opts.Stream {
+		return errors.New("-fail-on-missing cannot be used with -stream")
+	}
+
+	var blockErrors []error
+
+	var skip func(offset int64, err error)
+	if opts.KeepGoing {
+		skip = func(offset int64, err error) {
+			logger.Warn("skipped block", "offset", offset, "error", err)
+			blockErrors = append(blockErrors, fmt.Errorf("block at %d: %w", offset, err))
+		}
+	}
 
-			return nil, fmt.Errorf("failed to read line: %w", err)
+	// keep picks the pages when there is no index.
+	stream := func(dumpFileName string, index *stations.Index, keep func([]byte) bool, emit func(stations.Block) error) error {
+		if opts.Stream {
+			return in.streamPagesSequentially(ctx, dumpFileName, index, keep, emit)
 		}
 
-		records := bytes.SplitN(line, []byte(":"), 3)
+		return in.streamPages(ctx, dumpFileName, index, opts.Jobs, opts.Mmap, skip, emit)
+	}
 
-		shouldIndex := shouldIndex(records[2])
-		if !shouldIndex && last == 0 {
+	// Offsets are per dump file, so each gets an index of its own.
+	indexes := make([]*stations.Index, len(dumps))
+	for i, df := range dumps {
+		if df.index == "" {
 			continue
 		}
 
-		offset, err := strconv.ParseInt(string(records[0]), 10, 64)
+		extract := func() (*stations.Index, error) { return in.extractIndex(df.index, isListPage) }
+
+		var (
+			index *stations.Index
+			err   error
+		)
+		if opts.IndexCache != "" {
+			titles := fmt.Sprintf("%q %q %q", []string(opts.TitlePrefixes), opts.TitleRegexp, opts.OnlyTitle)
+			index, err = cachedIndex(opts.IndexCache, df.index, titles, extract)
+		} else {
+			index, err = extract()
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse offset: %w", err)
+			return fmt.Errorf("failed to extract index: %w", err)
+		}
+
+		logger.Info("extracted index", "file", df.index, "entries", len(index.OnID), "blocks", len(index.OnDump))
+
+		if err := checkDuplicateTitles(index, opts.Strict, opts.Verbose, stderr, logger); err != nil {
+			return fmt.Errorf("failed to extract index: %w", err)
 		}
 
-		if offset > last {
-			index.BlockSize[last] = offset - last
-			last = math.MaxInt64
+		indexes[i] = index
+	}
+
+	if opts.OnlyTitle != "" && !noIndex && !slices.ContainsFunc(indexes, func(index *stations.Index) bool { return index.OnTitle[opts.OnlyTitle] != nil }) {
+		return classify(errInput, fmt.Errorf("page %q not in the index", opts.OnlyTitle))
+	}
+
+	for _, index := range indexes {
+		if index != nil {
+			m.indexEntries += len(index.OnID)
 		}
+	}
+
+	if opts.DumpMatchedTitles != "" {
+		if err := writeMatchedTitles(opts.DumpMatchedTitles, stderr, indexes); err != nil {
+			return classify(errWrite, fmt.Errorf("failed to write matched titles: %w", err))
+		}
+	}
 
-		if shouldIndex {
-			id, err := strconv.ParseInt(string(records[1]), 10, 64)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse id: %w", err)
+	if opts.IndexOnly {
+		for _, index := range indexes {
+			if err := stations.WriteIndexTSV(stdout, index); err != nil {
+				return classify(errWrite, err)
 			}
+		}
+
+		return nil
+	}
+
+	var reject stations.Reject
+	if opts.Verbose || logger.Enabled(ctx, slog.LevelDebug) {
+		reject = func(s stations.Station, reason string) {
+			logger.Debug("rejected station", "name_en", s.NameEn, "name_kana", s.NameKana, "reason", reason)
 
-			index.OnDump[offset] = append(index.OnDump[offset], IndexEntry{
-				ID:     id,
-				Title:  string(records[2]),
-				Offset: offset,
-			})
-			e := &index.OnDump[offset][len(index.OnDump[offset])-1]
-			index.OnID[e.ID] = e
-			index.OnTitle[e.Title] = e
-
-			if offset != last {
-				index.BlockSize[last] = math.MaxInt64
-				last = offset
+			if opts.Verbose {
+				fmt.Fprintf(stderr, "%s (%s): %s\n", s.NameEn, s.NameKana, reason)
 			}
 		}
 	}
 
-	return &index, nil
-}
+	wantedPrefectures := (*stringList)(&opts.Prefectures).split()
 
-func extractPages(dumpFileName string, index *Index) ([]Page, error) {
-	f, err := os.Open(dumpFileName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open dump file: %w", err)
+	u := stations.NewUniquifier(dedup)
+
+	p := &progress{w: io.Discard}
+	if opts.Progress {
+		p.w = stderr
 	}
 
-	defer f.Close()
+	add := func(ss []stations.Station) {
+		ss = stations.FilterSparsePages(ss, opts.MinStations, reject)
+		ss = stations.UnwrapTemplates(ss)
+		ss = stations.UnescapeEntities(ss)
+		switch {
+		case opts.NoDisambig:
+		case opts.KeepDisambiguation:
+			ss = stations.ExtractDisambiguationsMatching(ss, disambigRx)
+		default:
+			ss = stations.RemoveDisambiguationsMatching(ss, disambigRx)
+		}
+		ss = stations.FoldWidth(ss)
+		if opts.NormalizeKana {
+			ss = stations.NormalizeKana(ss)
+		}
+		ss = stations.ComposeNFC(ss)
+		if opts.Romanize {
+			ss = stations.Romanize(ss, stations.RomanizeOptions{Macrons: opts.RomanizeMacrons})
+		}
+		ss = stations.FilterImplausible(ss, opts.MaxNameLength, reject)
+		if opts.NormalizeWhitespace {
+			ss = stations.NormalizeWhitespace(ss)
+		}
+		ss = stations.CheckKana(ss, opts.StrictKana, reject)
+		ss = stations.FilterPrefectures(ss, wantedPrefectures, reject)
+		ss = stations.FilterExcluded(ss, excludeRx, opts.ExcludeField, reject)
+
+		u.Add(ss...)
+	}
+
+	remaining := slices.Clone(indexes)
+
+	var cp *checkpoint
+	if opts.Checkpoint != "" {
+		var err error
+		if cp, err = loadCheckpoint(opts.Checkpoint, newCheckpointSource(dumps[0].dump, opts.Patterns)); err != nil {
+			return err
+		}
+
+		index := indexes[0]
+
+		offsets := make([]int64, 0, len(cp.Blocks))
+		for offset := range cp.Blocks {
+			if _, ok := index.OnDump[offset]; ok {
+				offsets = append(offsets, offset)
+			}
+		}
+
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+		for _, offset := range offsets {
+			add(cp.stations(offset))
+		}
+
+		remaining[0] = cp.remaining(index)
+	}
+
+	if !opts.Stream {
+		for _, index := range remaining {
+			p.blocksTotal += len(index.OnDump)
+		}
+	}
+
+	// The sequential reader emits the pages of a block one by one, so a block
+	// is only recorded once the next one starts.
+	var (
+		pendingOffset   int64 = -1
+		pendingStations []stations.Station
+	)
+
+	record := func() error {
+		if cp == nil || pendingOffset < 0 {
+			return nil
+		}
+
+		return classify(errWrite, cp.record(pendingOffset, pendingStations))
+	}
+
+	emit := func(b stations.Block) error {
+		for _, e := range b.Missing {
+			logger.Debug("missing page", "offset", b.Offset, "id", e.ID, "title", e.Title)
+
+			if opts.Verbose {
+				fmt.Fprintf(stderr, "%s (%d): not in the block at %d\n", e.Title, e.ID, b.Offset)
+			}
+		}
+
+		if len(b.Missing) > 0 && opts.FailOnMissing {
+			return classify(errParse, fmt.Errorf("%d indexed pages missing from the block at %d, so the index may be of another dump", len(b.Missing), b.Offset))
+		}
+
+		ss := extractStations(b.Pages, patterns, opts.PageTimeout, logger)
+		p.add(len(b.Pages), len(ss))
+
+		logger.Debug("decoded block", "offset", b.Offset, "pages", len(b.Pages), "stations", len(ss))
+		for _, page := range b.Pages {
+			if page.Redirect {
+				logger.Debug("skipped redirect", "title", page.Title)
+			}
+		}
+
+		if b.Offset != pendingOffset {
+			if err := record(); err != nil {
+				return err
+			}
+
+			pendingOffset, pendingStations = b.Offset, nil
+		}
+
+		pendingStations = append(pendingStations, ss...)
+
+		add(ss)
+
+		return nil
+	}
+
+	var err error
+	for i, df := range dumps {
+		if err = stream(df.dump, remaining[i], isListPage, emit); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = record()
+	}
+	p.finish()
+
+	m.pages, m.blocksFailed = p.pages, len(blockErrors)
+
+	interrupted := ctx.Err() != nil
+	if err != nil && !interrupted {
+		return fmt.Errorf("failed to extract pages: %w", err)
+	}
+
+	// The articles cannot be read once interrupted, so the partial output
+	// goes without them.
+	done := func() error {
+		if interrupted {
+			return fmt.Errorf("%w: %w", errInterrupted, ctx.Err())
+		}
+
+		return reportBlockErrors(stderr, blockErrors)
+	}
+
+	stationsOf := (*stations.Uniquifier).Stations
+	if opts.Sort == "none" {
+		stationsOf = (*stations.Uniquifier).Unsorted
+	}
+
+	ss := stationsOf(u)
+
+	if opts.DupStats > 0 {
+		reportDuplicates(stderr, u, opts.DupStats)
+	}
+
+	if (opts.Coords || opts.Since > 0) && !interrupted {
+		articles, err := readArticles(in, ss, dumps, stream)
+		if err != nil {
+			return fmt.Errorf("failed to read station articles: %w", err)
+		}
+
+		if opts.Coords {
+			stations.ResolveCoordinates(ss, articles)
+		}
+
+		if opts.Since > 0 {
+			stations.ResolveOpenedYears(ss, articles)
+			ss = stations.FilterSince(ss, opts.Since, opts.SinceStrict, reject)
+		}
+
+		u := stations.NewUniquifier(dedup)
+		u.Add(ss...)
+		ss = stationsOf(u)
+	}
+
+	if opts.Wikidata {
+		if err := in.resolveWikidata(ss, opts.WikidataDump); err != nil {
+			return fmt.Errorf("failed to resolve Wikidata IDs: %w", err)
+		}
+	}
+
+	switch opts.Sort {
+	case "kana":
+		stations.SortByKana(ss)
+	case "prefecture":
+		stations.SortByPrefecture(ss)
+	}
+
+	if ss, err = checkUTF8(ss, opts.StrictUTF8, reject); err != nil {
+		return err
+	}
+
+	if opts.RejectFile != "" {
+		if ss, err = writeRejects(opts.RejectFile, stdout, ss, output); err != nil {
+			return classify(errWrite, fmt.Errorf("failed to write rejected stations: %w", err))
+		}
+	}
+
+	if opts.FailIfEmpty && len(ss) == 0 && !interrupted {
+		return errors.New("no station found")
+	}
+
+	if len(ss) < opts.MinRows && !interrupted {
+		return fmt.Errorf("found %d stations, fewer than -min-rows %d", len(ss), opts.MinRows)
+	}
+
+	// -diff compares all the stations, not only those within -limit.
+	all := ss
+	ss = limit(ss, opts.Limit, opts.Shuffle, opts.Seed)
+
+	m.stations = len(ss)
+
+	if opts.Validate {
+		reportValidation(stderr, ss)
+	}
 
-	var pages []Page
+	if opts.CountBy != "" {
+		counts, _ := stations.CountBy(ss, opts.CountBy)
+		for _, c := range counts {
+			fmt.Fprintf(stderr, "%s\t%d\n", c.Value, c.N)
+		}
 
-	var buf bytes.Buffer
+		if !opts.CountByAndRows {
+			return done()
+		}
+	}
 
-	for offset, entries := range index.OnDump {
-		buf.Reset()
+	if opts.Count {
+		entries := 0
+		for _, index := range indexes {
+			if index == nil {
+				continue
+			}
 
-		if _, err := buf.ReadFrom(bzip2.NewReader(io.NewSectionReader(f, offset, index.BlockSize[offset]))); err != nil {
-			return nil, fmt.Errorf("failed to read dump file: %w", err)
+			for _, es := range index.OnDump {
+				entries += len(es)
+			}
 		}
 
-		var block Block
-		d := xml.NewDecoder(io.MultiReader(strings.NewReader("<block>"), &buf, strings.NewReader("</block>")))
-		if err := d.Decode(&block); err != nil {
-			return nil, fmt.Errorf("failed to decode pages: %w", err)
+		fmt.Fprintf(stderr, "matched index entries: %d\n", entries)
+		fmt.Fprintf(stderr, "decoded pages: %d\n", p.pages)
+		fmt.Fprintf(stderr, "station matches: %d\n", p.stations)
+		fmt.Fprintf(stderr, "unique stations: %d\n", len(ss))
+
+		return done()
+	}
+
+	if opts.Diff != "" {
+		previous, columns, err := in.readStations(opts.Diff)
+		if err != nil {
+			return classify(errInput, fmt.Errorf("failed to read -diff: %w", err))
 		}
 
-		for _, e := range entries {
-			for _, p := range block.Pages {
-				if p.ID == e.ID {
-					pages = append(pages, p)
-					break
+		// Fields missing from the earlier TSV cannot tell stations apart.
+		diff := dedup
+		if columns != nil && diff.Fields == nil {
+			for _, c := range columns {
+				if stations.CheckDedupFields([]string{c}) == nil {
+					diff.Fields = append(diff.Fields, c)
 				}
 			}
 		}
+
+		added, removed := diff.Diff(previous, all)
+
+		if !opts.OnlyAdded {
+			if err := writeDiff(stdout, added, removed); err != nil {
+				return classify(errWrite, fmt.Errorf("failed to write diff: %w", err))
+			}
+
+			return done()
+		}
+
+		ss = limit(added, opts.Limit, opts.Shuffle, opts.Seed)
+		m.stations = len(ss)
 	}
 
-	return pages, nil
+	for _, o := range targets {
+		if o.write == nil {
+			err = writeSQLite(o.name, ss, output)
+		} else {
+			err = writeOutput(o.name, stdout, o.write, ss)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", strings.ToUpper(o.format), classify(errWrite, err))
+		}
+
+		logger.Info("wrote stations", "format", o.format, "output", o.name, "stations", len(ss))
+	}
+
+	return done()
 }
 
-func extractStations(pages []Page) []Station {
-	var stations []Station
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
 
-	rx := regexp.MustCompile(`\|\[\[(?:[^|]+\|)?([^]]+)]]\s*\|\|\[\[:ja:[^|]+\|([^]]+)]][(（]([^）)]+)[）)]`)
+// target is one of the formats to write. write is nil for sqlite.
+type target struct {
+	format string
+	name   string
+	write  func(io.Writer, []stations.Station) error
+}
 
-	for _, p := range pages {
-		matches := rx.FindAllStringSubmatch(p.Revision.Text, -1)
-		for _, m := range matches {
-			stations = append(stations, Station{
-				Name:     m[2],
-				NameKana: m[3],
-				NameEn:   m[1],
-			})
+// extractStations runs stations.ExtractStations page by page, giving up on the
+// pages taking longer than timeout unless it is zero. A page given up on
+// keeps its goroutine busy until it is done, but its stations are dropped.
+func extractStations(pages []stations.Page, patterns []*stations.Pattern, timeout time.Duration, logger *slog.Logger) []stations.Station {
+	return extractStationsWith(stations.ExtractStations, pages, patterns, timeout, logger)
+}
+
+// extractStationsWith is extractStations matching the pages with extract.
+func extractStationsWith(extract func([]stations.Page, []*stations.Pattern) []stations.Station, pages []stations.Page, patterns []*stations.Pattern, timeout time.Duration, logger *slog.Logger) []stations.Station {
+	if timeout <= 0 {
+		return extract(pages, patterns)
+	}
+
+	var ss []stations.Station
+
+	for _, page := range pages {
+		done := make(chan []stations.Station, 1)
+		go func(page stations.Page) {
+			done <- extract([]stations.Page{page}, patterns)
+		}(page)
+
+		timer := time.NewTimer(timeout)
+
+		select {
+		case pss := <-done:
+			ss = append(ss, pss...)
+		case <-timer.C:
+			logger.Warn("skipped page taking too long to match", "title", page.Title, "timeout", timeout)
 		}
+
+		timer.Stop()
+	}
+
+	return ss
+}
+
+// limit returns the first n of ss, or n of them at random if shuffle, or
+// all of them if n is 0.
+func limit(ss []stations.Station, n int, shuffle bool, seed int64) []stations.Station {
+	if n <= 0 || len(ss) <= n {
+		return ss
 	}
 
-	return stations
+	if shuffle {
+		return sample(ss, n, seed)
+	}
+
+	return ss[:n]
 }
 
-func removeDisambiguations(stations []Station) []Station {
-	rx := regexp.MustCompile(`\s*[(（][^）)]*[）)].*`)
+// sample picks n of ss at random, reproducibly for the same seed, keeping
+// them in the order of ss.
+func sample(ss []stations.Station, n int, seed int64) []stations.Station {
+	picked := rand.New(rand.NewSource(seed)).Perm(len(ss))[:n]
 
-	ss := make([]Station, len(stations))
+	sort.Ints(picked)
+
+	sampled := make([]stations.Station, n)
+	for i, j := range picked {
+		sampled[i] = ss[j]
+	}
 
-	for i, s := range stations {
-		ss[i] = Station{
-			Name:     rx.ReplaceAllString(s.Name, ""),
-			NameKana: rx.ReplaceAllString(s.NameKana, ""),
-			NameEn:   rx.ReplaceAllString(s.NameEn, ""),
+	return sampled
+}
+
+// reportDuplicates prints how many stations deduplication collapsed and the
+// n most duplicated ones.
+func reportDuplicates(w io.Writer, u *stations.Uniquifier, n int) {
+	ds := u.Duplicates()
+
+	fmt.Fprintf(w, "stations before deduplication: %d\n", u.Added())
+	fmt.Fprintf(w, "stations after deduplication: %d\n", len(u.Stations()))
+
+	for _, d := range ds[:min(n, len(ds))] {
+		fmt.Fprintf(w, "%d\t%s (%s)\n", d.Count, d.Station.NameEn, d.Station.NameKana)
+	}
+}
+
+// checkUTF8 replaces the invalid UTF-8 in ss with U+FFFD, or drops the
+// stations having it or fails on the first as mode is "drop" or "error".
+func checkUTF8(ss []stations.Station, mode string, reject stations.Reject) ([]stations.Station, error) {
+	switch mode {
+	case "drop":
+		return stations.FilterInvalidUTF8(ss, reject), nil
+	case "error":
+		var invalid error
+		stations.FilterInvalidUTF8(ss, func(s stations.Station, reason string) {
+			if invalid == nil {
+				invalid = fmt.Errorf("%s (%s): %s", s.NameEn, s.NameKana, reason)
+			}
+		})
+		if invalid != nil {
+			return nil, classify(errParse, invalid)
 		}
+
+		return ss, nil
+	default:
+		return stations.ReplaceInvalidUTF8(ss), nil
 	}
+}
 
-	return ss
+// writeRejects writes the stations failing validation to the file name, or
+// to stdout if name is -, with the reasons, returning the others.
+func writeRejects(name string, stdout io.Writer, ss []stations.Station, output stations.OutputOptions) ([]stations.Station, error) {
+	var (
+		valid, rejected []stations.Station
+		reasons         []string
+	)
+
+	for _, s := range ss {
+		if err := s.Validate(); err != nil {
+			rejected = append(rejected, s)
+			reasons = append(reasons, err.Error())
+		} else {
+			valid = append(valid, s)
+		}
+	}
+
+	err := writeOutput(name, stdout, func(w io.Writer, ss []stations.Station) error {
+		return output.WriteRejectsTSV(w, ss, reasons)
+	}, rejected)
+	if err != nil {
+		return nil, err
+	}
+
+	return valid, nil
+}
+
+// writeMatchedTitles writes the titles in the indexes one per line in sorted
+// order to the file name, replacing it atomically, or to stderr if name is -.
+func writeMatchedTitles(name string, stderr io.Writer, indexes []*stations.Index) error {
+	var titles []string
+	for _, index := range indexes {
+		for title := range index.OnTitle {
+			titles = append(titles, title)
+		}
+	}
+
+	sort.Strings(titles)
+
+	if name == "-" {
+		bw := bufio.NewWriter(stderr)
+		for _, title := range titles {
+			fmt.Fprintln(bw, title)
+		}
+
+		return bw.Flush()
+	}
+
+	return writeFileAtomic(name, 0o644, func(w io.Writer) error {
+		for _, title := range titles {
+			if _, err := fmt.Fprintln(w, title); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// checkDuplicateTitles reports the titles the index lists for two pages,
+// failing on the first if strict.
+func checkDuplicateTitles(index *stations.Index, strict, verbose bool, w io.Writer, logger *slog.Logger) error {
+	for _, d := range index.DuplicateTitles {
+		if strict {
+			return classify(errParse, fmt.Errorf("title %q listed for pages %d and %d", d.Title, d.PreviousID, d.ID))
+		}
+
+		logger.Debug("duplicate title in index", "title", d.Title, "previous_id", d.PreviousID, "id", d.ID)
+
+		if verbose {
+			fmt.Fprintf(w, "%s: listed for pages %d and %d, using %d only\n", d.Title, d.PreviousID, d.ID, d.ID)
+		}
+	}
+
+	return nil
+}
+
+// reportValidation prints the number of valid stations and those of the
+// invalid ones by reason.
+func reportValidation(w io.Writer, ss []stations.Station) {
+	valid := 0
+	invalid := make(map[string]int)
+
+	for _, s := range ss {
+		if err := s.Validate(); err != nil {
+			invalid[err.Error()]++
+		} else {
+			valid++
+		}
+	}
+
+	reasons := make([]string, 0, len(invalid))
+	for reason := range invalid {
+		reasons = append(reasons, reason)
+	}
+
+	sort.Strings(reasons)
+
+	fmt.Fprintf(w, "valid stations: %d\n", valid)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "invalid stations (%s): %d\n", reason, invalid[reason])
+	}
+}
+
+// reportBlockErrors prints the errors of the blocks skipped by -keep-going and
+// fails if there are any.
+func reportBlockErrors(w io.Writer, errs []error) error {
+	for _, err := range errs {
+		fmt.Fprintln(w, err)
+	}
+
+	if len(errs) > 0 {
+		return classify(errParse, fmt.Errorf("failed to decode %d blocks", len(errs)))
+	}
+
+	return nil
+}
+
+// stringList is a flag.Value collecting every occurrence of a flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// split returns the comma-separated values of every occurrence.
+func (l *stringList) split() []string {
+	var values []string
+	for _, s := range *l {
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+
+	return values
+}
+
+// progress periodically reports how far the extraction has got. Blocks are
+// only counted when the dump is read block by block.
+type progress struct {
+	w           io.Writer
+	blocksTotal int
+	blocks      int
+	pages       int
+	stations    int
+	reported    time.Time
+}
+
+func (p *progress) add(pages, stations int) {
+	if p.blocksTotal > 0 {
+		p.blocks++
+	}
+	p.pages += pages
+	p.stations += stations
+
+	if time.Since(p.reported) >= time.Second {
+		p.report()
+	}
+}
+
+func (p *progress) finish() {
+	p.report()
+}
+
+func (p *progress) report() {
+	p.reported = time.Now()
+
+	if p.blocksTotal > 0 {
+		fmt.Fprintf(p.w, "blocks %d/%d, pages %d, stations %d\n", p.blocks, p.blocksTotal, p.pages, p.stations)
+	} else {
+		fmt.Fprintf(p.w, "pages %d, stations %d\n", p.pages, p.stations)
+	}
+}
+
+// newLogHandler makes the handler of the logs written to w; tests replace it
+// to record them.
+var newLogHandler = func(w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+	return slog.NewTextHandler(w, opts)
+}
+
+func (in files) extractIndex(indexFileName string, shouldIndex func([]byte) bool) (*stations.Index, error) {
+	f, err := in.open(indexFileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index file: %w", classify(errInput, err))
+	}
+
+	defer f.Close()
+
+	zr, err := stations.DecompressorFor(indexFileName)(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress index file: %w", classify(errParse, err))
+	}
+
+	index, err := stations.ExtractIndexWithOptions(zr, shouldIndex, in.index)
+
+	return index, classify(errParse, err)
 }
 
-func uniquify(stations []Station) []Station {
-	sorted := append([]Station(nil), stations...)
+func (in files) streamPages(ctx context.Context, dumpFileName string, index *stations.Index, jobs int, useMmap bool, skip func(int64, error), emit func(stations.Block) error) error {
+	f, err := in.open(dumpFileName)
+	if err != nil {
+		return fmt.Errorf("failed to open dump file: %w", classify(errInput, err))
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat dump file: %w", classify(errInput, err))
+	}
+
+	index.SetDumpSize(fi.Size())
 
-	sort.Slice(sorted, func(i, j int) bool { return sorted[i].NameEn < sorted[j].NameEn })
+	var (
+		r        io.ReaderAt = f
+		inMemory bool
+	)
 
-	uniquified := make([]Station, 0, len(sorted))
+	if useMmap {
+		if m, ok := mmap(f); ok {
+			defer m.Close()
 
-	for i, s := range sorted {
-		if i == 0 || s != uniquified[len(uniquified)-1] {
-			uniquified = append(uniquified, s)
+			r, inMemory = m, true
 		}
 	}
 
-	return uniquified
+	err = stations.StreamPagesContext(ctx, r, index, stations.ExtractOptions{
+		Decompress: stations.DecompressorFor(dumpFileName),
+		Jobs:       jobs,
+		Skip:       skip,
+		InMemory:   inMemory,
+	}, emit)
+	if ctx.Err() != nil {
+		return err
+	}
+
+	return classify(errParse, err)
 }
 
-func writeTSV(w io.Writer, stations []Station) error {
-	wr := csv.NewWriter(w)
-	wr.Comma = '\t'
+// streamPagesSequentially picks the pages by index, or by keep if index is
+// nil.
+func (in files) streamPagesSequentially(ctx context.Context, dumpFileName string, index *stations.Index, keep func([]byte) bool, emit func(stations.Block) error) error {
+	r := in.stdin
 
-	if err := wr.Write([]string{"name", "name_kana", "name_en"}); err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
+	if dumpFileName != "-" {
+		f, err := in.open(dumpFileName)
+		if err != nil {
+			return fmt.Errorf("failed to open dump file: %w", classify(errInput, err))
+		}
+
+		defer f.Close()
+
+		r = f
+	}
+
+	zr, err := stations.DecompressorFor(dumpFileName)(r)
+	if err != nil {
+		return fmt.Errorf("failed to decompress dump file: %w", classify(errParse, err))
+	}
+
+	if index == nil {
+		err = stations.StreamPagesByTitleContext(ctx, zr, keep, emit)
+	} else {
+		err = stations.StreamPagesSequentiallyContext(ctx, zr, index, emit)
+	}
+	if ctx.Err() != nil {
+		return err
+	}
+
+	return classify(errParse, err)
+}
+
+var dumpDateRegexp = regexp.MustCompile(`^[a-z_]+wiki-(\d{8})-`)
+
+// dumpDate returns the date in the name of a dump file, or fallback if it is
+// not named the way Wikimedia does.
+func dumpDate(dumpFileName, fallback string) string {
+	if m := dumpDateRegexp.FindStringSubmatch(filepath.Base(dumpFileName)); m != nil {
+		return m[1]
 	}
 
-	for _, s := range stations {
-		if err := wr.Write([]string{s.Name, s.NameKana, s.NameEn}); err != nil {
-			return fmt.Errorf("failed to write body: %w", err)
+	return fallback
+}
+
+// dumpFiles is a multistream dump file and its index file.
+type dumpFiles struct {
+	dump, index string
+}
+
+// readArticles looks up the articles of the stations, which requires a second
+// pass over the indexes and the dumps.
+func readArticles(in files, ss []stations.Station, dumps []dumpFiles, stream func(string, *stations.Index, func([]byte) bool, func(stations.Block) error) error) ([]stations.Page, error) {
+	articles := make(map[string]bool)
+	for _, s := range ss {
+		articles[s.Article] = true
+	}
+
+	isArticle := func(title []byte) bool { return articles[string(title)] }
+
+	var pages []stations.Page
+
+	for _, df := range dumps {
+		var index *stations.Index
+		if df.index != "" {
+			var err error
+			if index, err = in.extractIndex(df.index, isArticle); err != nil {
+				return nil, fmt.Errorf("failed to extract index: %w", err)
+			}
+		}
+
+		err := stream(df.dump, index, isArticle, func(b stations.Block) error {
+			pages = append(pages, b.Pages...)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract pages: %w", err)
 		}
 	}
 
-	wr.Flush()
+	return pages, nil
+}
+
+func (in files) resolveWikidata(ss []stations.Station, dumpFileName string) error {
+	wanted := make(map[stations.Sitelink]bool)
+	for _, s := range ss {
+		wanted[stations.Sitelink{Site: "jawiki", Title: s.JaTitle}] = true
+		wanted[stations.Sitelink{Site: "enwiki", Title: s.Article}] = true
+	}
+
+	f, err := in.open(dumpFileName)
+	if err != nil {
+		return fmt.Errorf("failed to open Wikidata dump: %w", classify(errInput, err))
+	}
+
+	defer f.Close()
+
+	zr, err := stations.DecompressorFor(dumpFileName)(f)
+	if err != nil {
+		return fmt.Errorf("failed to decompress Wikidata dump: %w", classify(errParse, err))
+	}
+
+	links, err := stations.ReadSitelinks(zr, func(l stations.Sitelink) bool { return wanted[l] })
+	if err != nil {
+		return fmt.Errorf("failed to read Wikidata dump: %w", classify(errParse, err))
+	}
+
+	stations.ResolveWikidata(ss, links)
+
+	return nil
+}
+
+func writeOutput(outputName string, stdout io.Writer, write func(io.Writer, []stations.Station) error, ss []stations.Station) (err error) {
+	if outputName == "" || outputName == "-" {
+		return write(stdout, ss)
+	}
+
+	f, err := os.OpenFile(outputName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+
+	defer func() {
+		fi, serr := f.Stat()
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("failed to close output file: %w", cerr)
+		}
+		if err != nil && serr == nil && fi.Mode().IsRegular() {
+			_ = os.Remove(outputName)
+		}
+	}()
+
+	return write(f, ss)
+}
+
+// gzipped wraps write to gzip what it writes, closing the gzip stream so that
+// its footer is written.
+func gzipped(write func(io.Writer, []stations.Station) error) func(io.Writer, []stations.Station) error {
+	return func(w io.Writer, ss []stations.Station) error {
+		zw := gzip.NewWriter(w)
+
+		if err := write(zw, ss); err != nil {
+			return err
+		}
+
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to close gzip stream: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// sqliteUniqueColumns are the columns of the sqlite table that can be
+// compared in deduplication, in the order of the table.
+var sqliteUniqueColumns = []string{"name", "name_kana", "name_en", "disambiguation", "prefecture", "operator", "line", "code", "lat", "lon"}
+
+// writeSQLite writes ss into the stations table of the database at path,
+// with a unique index over the columns compared in deduplication. The rows
+// told apart only by the fields the table lacks, such as opened_year, are
+// written once.
+func writeSQLite(path string, ss []stations.Station, output stations.OutputOptions) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	qs := []string{
+		`DROP TABLE IF EXISTS stations`,
+		`CREATE TABLE stations (id INTEGER, name TEXT NOT NULL, name_kana TEXT NOT NULL, name_en TEXT NOT NULL, disambiguation TEXT NOT NULL, prefecture TEXT NOT NULL, operator TEXT NOT NULL, line TEXT NOT NULL, code TEXT NOT NULL, lat REAL, lon REAL, source TEXT)`,
+	}
+
+	compared := output.Dedup.ComparedFields()
+	var unique []string
+	for _, c := range sqliteUniqueColumns {
+		if slices.Contains(compared, c) {
+			unique = append(unique, c)
+		}
+	}
+	if unique != nil {
+		qs = append(qs, `CREATE UNIQUE INDEX stations_unique ON stations (`+strings.Join(unique, ", ")+`)`)
+	}
+
+	for _, q := range qs {
+		if _, err := tx.Exec(q); err != nil {
+			return fmt.Errorf("failed to create table: %w", err)
+		}
+	}
+
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO stations (id, name, name_kana, name_en, disambiguation, prefecture, operator, line, code, lat, lon, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+
+	defer stmt.Close()
+
+	for _, s := range ss {
+		id := sql.NullInt64{Int64: output.Dedup.ID(s), Valid: output.WithID}
+		source := sql.NullString{String: s.Source, Valid: output.Provenance}
+		if _, err := stmt.Exec(id, s.Name, s.NameKana, s.NameEn, s.Disambiguation, s.Prefecture, s.Operator, s.Line, s.Code, nullCoordinate(s.Lat), nullCoordinate(s.Lon), source); err != nil {
+			return fmt.Errorf("failed to insert station: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 
 	return nil
 }
+
+func nullCoordinate(f float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: f, Valid: f != 0}
+}
@@ -0,0 +1,2099 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hirofumi/railway-stations-in-japan/stations"
+)
+
+func TestWriteOutput(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out.tsv")
+	ss := []stations.Station{{Name: "赤羽駅", NameKana: "あかばね", NameEn: "Akabane"}}
+
+	if err := writeOutput(name, io.Discard, stations.WriteTSV, ss); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := os.ReadFile(name); err != nil || !strings.Contains(string(b), "Akabane") {
+		t.Errorf("got %q, %v, want the stations written", b, err)
+	}
+
+	errFailed := errors.New("failed")
+	err := writeOutput(name, io.Discard, func(w io.Writer, ss []stations.Station) error {
+		stations.WriteTSV(w, ss)
+		return errFailed
+	}, ss)
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("got %v, want %v", err, errFailed)
+	}
+
+	if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want the partial output removed", err)
+	}
+}
+
+// testTSV is the output for testBlocks without flags.
+const testTSV = `name	name_kana	name_en	prefecture	operator	line
+我孫子駅	あびこ	Abiko	Chiba	JR East	Jōban Line
+赤羽駅	あかばね	Akabane	Tokyo	JR East	Keihin-Tōhoku Line
+赤羽駅	あかばね	Akabane	Tokyo	JR East	Saikyō Line
+赤羽駅	あかばね	Akabane			
+番田駅	ばんだ	Banda			
+番田駅	ばんだ	Banda Station			
+千葉駅	ちば	Chiba			
+千葉みなと駅	ちばminato	Chiba-minato			
+代官山駅	だいかんやま	Daikanyama	Tokyo	Tokyu	Tōyoko Line
+道後温泉駅	どうごおんせん	Dōgo Onsen Station	Ehime		
+千葉駅	ちば	chiba			
+`
+
+// The fixture dump, generated by stations/testdata/gen.py, holds testBlocks
+// and F in its last block, whose stream is followed by the footer of the dump.
+const (
+	fixtureDump  = "stations/testdata/multistream.xml.bz2"
+	fixtureIndex = "stations/testdata/multistream-index.txt.bz2"
+)
+
+// fixtureTSV is the output for the fixture dump without flags.
+var fixtureTSV = strings.Replace(testTSV, "千葉駅\tちば\tchiba", "府中駅\tふちゅう\tFuchū\tTokyo\tKeio\tKeio Line\n千葉駅\tちば\tchiba", 1)
+
+func TestStream(t *testing.T) {
+	f, err := os.Open(fixtureDump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+
+	for _, args := range [][]string{
+		{"-d", fixtureDump, "-i", fixtureIndex},
+		{"-d", fixtureDump, "-i", fixtureIndex, "-stream"},
+		{"-d", "-", "-i", fixtureIndex, "-stream"},
+	} {
+		got, _, err := runMain(t, args...)
+		if err != nil {
+			t.Fatalf("%q: %v", args, err)
+		}
+
+		if got != fixtureTSV {
+			t.Errorf("%q: got\n%s\nwant\n%s", args, got, fixtureTSV)
+		}
+	}
+}
+
+// testPage is a page of the dumps written by writeDump.
+type testPage struct {
+	id    int64
+	title string
+	text  string
+}
+
+// testBlocks are the blocks of the test dump: list pages in the layouts of
+// stations.DefaultPatterns among other pages, and the station articles.
+var testBlocks = [][]testPage{
+	{{10, "Other page", "nothing here"}},
+	{
+		{20, "List of railway stations in Japan: A", `{| class="wikitable"
+|-
+|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || JK38 || [[Tokyo]] || [[East Japan Railway Company|JR East]] || [[Keihin-Tōhoku Line]]
+|-
+|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || [[Tokyo]] || JR East || [[Saikyō Line]]
+|-
+|[[Abiko Station (Chiba)|Abiko (Chiba)]] ||[[:ja:我孫子駅 (千葉県)|我孫子駅]]（あびこ） || [[Chiba Prefecture|Chiba]] || JR East || [[Jōban Line]]
+|}`},
+		{21, "Unrelated", "x"},
+		{22, "List of railway stations in Japan: E", "  #redirect [[List of railway stations in Japan: A]]\n|[[Bogus Station|Bogus]] ||[[:ja:偽駅|偽駅]]（にせ）"},
+	},
+	{{30, "Something", "y"}},
+	{
+		{40, "List of railway stations in Japan: B", `|[[Banda Station]] ||[[:ja:番田駅|番田駅]]（ばんだ）
+|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね）
+|[[Banda Station|Banda]] ||[[:ja:番田駅|番田駅]]（ばんだ）
+|[[Banda Station]] ||[[:ja:番田駅|番田駅]]（ばんだ）`},
+		{41, "List of railway stations in Japan: C", `|[[Chiba Station|Chiba]] ||[[:ja:千葉駅|千葉駅]]（ちば）
+|[[Chiba-minato Station|Chiba-minato]] ||[[:ja:千葉みなと駅|千葉みなと駅]]（ちばminato）
+|[[Chiba Station|chiba]] ||[[:ja:千葉駅|千葉駅]]（ちば）`},
+	},
+	{{45, "List of railway stations in Japan: D", `{| class="wikitable"
+|-
+|[[Daikanyama Station|Daikanyama]]
+|[[:ja:代官山駅|代官山駅]]（だいかんやま）
+|[[Tokyo]]
+|Tokyu
+|[[Tōyoko Line]]
+|-
+|[[Dōgo Onsen Station]] || 道後温泉駅（どうごおんせん） || Ehime
+|}`}},
+	{
+		{50, "Zzz", "z"},
+		{51, "Akabane Station", "{{Infobox station|coordinates = {{coord|35|46|40|N|139|43|15|E|display=inline}}\n| opened = {{Start date|1885|3|1}}}}"},
+	},
+	{{60, "Chiba Station", "{{coord|35.6130|140.1135}}"}},
+}
+
+// writeDump writes blocks as a multistream dump and its index, uncompressed
+// or gzipped as ext (.xml or .gz) says, between the header and the footer
+// of a real dump, returning their paths.
+func writeDump(t testing.TB, ext string, blocks [][]testPage) (dump, index string) {
+	t.Helper()
+
+	streams := []string{"<mediawiki>\n  <siteinfo><sitename>Wikipedia</sitename></siteinfo>\n"}
+	for _, b := range blocks {
+		var sb strings.Builder
+		for _, p := range b {
+			fmt.Fprintf(&sb, "  <page>\n    <title>%s</title>\n    <ns>0</ns>\n    <id>%d</id>\n    <revision><id>1</id><text>%s</text></revision>\n  </page>\n", escape(p.title), p.id, escape(p.text))
+		}
+		streams = append(streams, sb.String())
+	}
+	streams = append(streams, "</mediawiki>\n")
+
+	var d, i bytes.Buffer
+	for n, s := range streams {
+		if n > 0 && n < len(streams)-1 {
+			for _, p := range blocks[n-1] {
+				fmt.Fprintf(&i, "%d:%d:%s\n", d.Len(), p.id, p.title)
+			}
+		}
+
+		if ext == ".gz" {
+			zw := gzip.NewWriter(&d)
+			zw.Write([]byte(s))
+			zw.Close()
+		} else {
+			d.WriteString(s)
+		}
+	}
+
+	dir := t.TempDir()
+	dump = filepath.Join(dir, "dump"+map[string]string{".xml": ".xml", ".gz": ".xml.gz"}[ext])
+	index = filepath.Join(dir, "index.txt")
+
+	if err := os.WriteFile(dump, d.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(index, i.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return dump, index
+}
+
+var escape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+
+// testOptions returns the options reading dump and index with the output
+// and the errors going to buffers.
+func testOptions(dump, index string) (Options, *bytes.Buffer, *bytes.Buffer) {
+	var stdout, stderr bytes.Buffer
+
+	opts := DefaultOptions()
+	opts.Dump, opts.Index = dump, index
+	opts.Jobs = 1
+	opts.Stdout, opts.Stderr = &stdout, &stderr
+
+	return opts, &stdout, &stderr
+}
+
+// runTest runs opts, failing the test on an error.
+func runTest(t *testing.T, opts Options) {
+	t.Helper()
+
+	if err := RunContext(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	opts, stdout, _ := testOptions(writeDump(t, ".xml", testBlocks))
+	runTest(t, opts)
+
+	if got := stdout.String(); got != testTSV {
+		t.Errorf("got\n%s\nwant\n%s", got, testTSV)
+	}
+}
+
+// cancelingWriter cancels a context on the first write.
+type cancelingWriter struct {
+	cancel context.CancelFunc
+}
+
+func (w cancelingWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return len(p), nil
+}
+
+func TestRunContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The progress is first reported after the first list page block.
+	opts, stdout, _ := testOptions(writeDump(t, ".xml", testBlocks))
+	opts.Progress = true
+	opts.Stderr = cancelingWriter{cancel}
+
+	err := RunContext(ctx, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+
+	if got := exitCode(err); got != 130 {
+		t.Errorf("got exit code %d, want 130", got)
+	}
+
+	if got := strings.Count(stdout.String(), "\n"); got >= strings.Count(testTSV, "\n") {
+		t.Errorf("got %d lines, want fewer than without canceling", got)
+	}
+}
+
+func TestGzipDump(t *testing.T) {
+	dump, index := writeDump(t, ".gz", testBlocks)
+
+	for _, args := range [][]string{
+		{"-d", dump, "-i", index},
+		{"-d", dump, "-i", index, "-stream"},
+	} {
+		got, _, err := runMain(t, args...)
+		if err != nil {
+			t.Fatalf("%q: %v", args, err)
+		}
+
+		if got != testTSV {
+			t.Errorf("%q: got\n%s\nwant\n%s", args, got, testTSV)
+		}
+	}
+}
+
+// runMain runs the command with args as main parses them, returning what it
+// wrote to stdout and stderr.
+func runMain(t *testing.T, args ...string) (stdout, stderr string, err error) {
+	t.Helper()
+
+	opts, out, errOut := testOptions("", "")
+	opts.Jobs = DefaultOptions().Jobs
+
+	fs := flag.NewFlagSet("railway-stations-in-japan", flag.ContinueOnError)
+	opts.flags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	err = RunContext(context.Background(), opts)
+
+	return out.String(), errOut.String(), err
+}
+
+func TestDefaultFileNames(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "enwiki-20210920-pages-articles-multistream-index.txt.bz2"},
+		{[]string{"-date", "20240401", "-lang", "ja"}, "jawiki-20240401-pages-articles-multistream-index.txt.bz2"},
+		{[]string{"-date", "20240401", "-i", "index.txt.bz2"}, "index.txt.bz2"},
+	} {
+		_, _, err := runMain(t, tt.args...)
+		if !errors.Is(err, os.ErrNotExist) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got %v, want a missing %s", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+	lines := strings.SplitAfter(testTSV, "\n")
+
+	for _, tt := range []struct {
+		limit string
+		want  string
+	}{
+		{"0", testTSV},
+		{"2", strings.Join(lines[:3], "")},
+		{"100", testTSV},
+	} {
+		got, _, err := runMain(t, "-d", dump, "-i", index, "-limit", tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("-limit %s: got\n%s\nwant\n%s", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestJobs(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	var want string
+	for _, jobs := range []string{"1", "4"} {
+		got, _, err := runMain(t, "-d", dump, "-i", index, "-coords", "-jobs", jobs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if jobs == "1" {
+			want = got
+		} else if got != want {
+			t.Errorf("-jobs %s: got\n%s\nwant as with -jobs 1\n%s", jobs, got, want)
+		}
+	}
+
+	if !strings.Contains(want, "\t35.613\t140.1135\n") {
+		t.Errorf("got no coordinates for Chiba Station in\n%s", want)
+	}
+}
+
+func TestSince(t *testing.T) {
+	dump, index := writeDump(t, ".xml", [][]testPage{
+		{{10, "List of railway stations in Japan: A", `|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね）
+|[[Banda Station|Banda]] ||[[:ja:番田駅|番田駅]]（ばんだ）
+|[[Chiba Station|Chiba]] ||[[:ja:千葉駅|千葉駅]]（ちば）`}},
+		{
+			{20, "Akabane Station", "{{Infobox station\n| opened = {{Start date|1885|3|1}}\n}}"},
+			{21, "Banda Station", "{{Infobox station}}"},
+		},
+		{{30, "Chiba Station", "{{Infobox station\n| opened = 20 July 1894\n}}"}},
+	})
+
+	for _, tt := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-since", "1890"}, []string{"Banda", "Chiba"}},
+		{[]string{"-since", "1890", "-since-strict"}, []string{"Chiba"}},
+		{[]string{"-since", "1880", "-since-strict"}, []string{"Akabane", "Chiba"}},
+	} {
+		got, _, err := runMain(t, append([]string{"-d", dump, "-i", index}, tt.args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, line := range strings.Split(strings.TrimSpace(got), "\n")[1:] {
+			names = append(names, strings.Split(line, "\t")[2])
+		}
+
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.args, names, tt.want)
+		}
+	}
+
+	if _, _, err := runMain(t, "-d", "-", "-i", index, "-since", "1890"); err == nil {
+		t.Error("got no error for -since with the dump from stdin")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	for _, tt := range []struct {
+		blocksTotal int
+		want        string
+	}{
+		{2, "blocks 1/2, pages 3, stations 2\nblocks 2/2, pages 4, stations 3\n"},
+		{0, "pages 3, stations 2\npages 4, stations 3\n"},
+	} {
+		var buf bytes.Buffer
+		p := &progress{w: &buf, blocksTotal: tt.blocksTotal}
+		p.add(3, 2)
+		p.add(1, 1)
+		p.finish()
+
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%d blocks: got %q, want %q", tt.blocksTotal, got, tt.want)
+		}
+	}
+}
+
+func TestPatternFlag(t *testing.T) {
+	dump, index := writeDump(t, ".xml", [][]testPage{{{90, "List of railway stations in Japan: G", "* Gotanda / 五反田駅 / ごたんだ"}}})
+
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-pattern", `\* (\w+) / (\S+) / (\S+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "name\tname_kana\tname_en\tprefecture\toperator\tline\n五反田駅\tごたんだ\tGotanda\t\t\t\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-pattern", `(\w+)`); err == nil {
+		t.Error("got no error for a pattern without three groups")
+	}
+}
+
+func TestCount(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	stdout, stderr, err := runMain(t, "-d", dump, "-i", index, "-count")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout != "" {
+		t.Errorf("got stations written %q, want none", stdout)
+	}
+
+	if want := "matched index entries: 5\ndecoded pages: 5\nstation matches: 12\nunique stations: 11\n"; stderr != want {
+		t.Errorf("got %q, want %q", stderr, want)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{errors.New("other"), 1},
+		{classify(errInput, os.ErrNotExist), 2},
+		{classify(errParse, errors.New("bad line")), 3},
+		{fmt.Errorf("failed to write: %w", classify(errWrite, os.ErrClosed)), 4},
+		{fmt.Errorf("%w: %w", errInterrupted, context.Canceled), 130},
+		{classify(errParse, classify(errInput, os.ErrNotExist)), 2},
+	} {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestExitCodeMissingIndex(t *testing.T) {
+	dump, _ := writeDump(t, ".xml", testBlocks)
+
+	_, _, err := runMain(t, "-d", dump, "-i", filepath.Join(t.TempDir(), "missing.txt"))
+	if got := exitCode(err); got != 2 {
+		t.Errorf("got exit code %d for %v, want 2", got, err)
+	}
+}
+
+func TestPrefectureFlag(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-prefecture", "Chiba,ehime")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "name\tname_kana\tname_en\tprefecture\toperator\tline\n我孫子駅\tあびこ\tAbiko\tChiba\tJR East\tJōban Line\n道後温泉駅\tどうごおんせん\tDōgo Onsen Station\tEhime\t\t\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFoldCaseFlag(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-fold-case")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := strings.Replace(testTSV, "千葉駅\tちば\tchiba\t\t\t\n", "", 1); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStrictKana(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	stdout, stderr, err := runMain(t, "-d", dump, "-i", index, "-verbose")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(stdout, "Chiba-minato") || !strings.Contains(stderr, "Chiba-minato (ちばminato): kana has non-kana characters") {
+		t.Errorf("got\n%s\n%s\nwant Chiba-minato kept with a warning", stdout, stderr)
+	}
+
+	stdout, _, err = runMain(t, "-d", dump, "-i", index, "-strict-kana")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(stdout, "Chiba-minato") {
+		t.Errorf("got\n%s\nwant Chiba-minato dropped with -strict-kana", stdout)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	for _, flags := range [][]string{nil, {"-stream"}, {"-coords"}} {
+		args := append([]string{"-d", dump, "-i", index}, flags...)
+
+		want, _, err := runMain(t, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dir := t.TempDir()
+		path := filepath.Join(dir, "checkpoint.json")
+		args = append(args, "-checkpoint", path)
+
+		if got, _, err := runMain(t, args...); err != nil || got != want {
+			t.Fatalf("%q: got\n%s\n%v\nwant\n%s", flags, got, err, want)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var full checkpoint
+		if err := json.Unmarshal(b, &full); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(full.Blocks) != 3 {
+			t.Fatalf("%q: got %d blocks recorded, want the 3 with list pages", flags, len(full.Blocks))
+		}
+
+		// Keep the first two blocks as if the run had been interrupted
+		// after them.
+		var offsets []int64
+		for offset := range full.Blocks {
+			offsets = append(offsets, offset)
+		}
+		slices.Sort(offsets)
+
+		partial := checkpoint{checkpointSource: full.checkpointSource, Blocks: map[int64][]checkpointStation{offsets[0]: full.Blocks[offsets[0]], offsets[1]: full.Blocks[offsets[1]]}}
+
+		b, err = json.Marshal(partial)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, _, err := runMain(t, args...); err != nil || got != want {
+			t.Errorf("%q resumed: got\n%s\n%v\nwant\n%s", flags, got, err, want)
+		}
+
+		var resumed checkpoint
+		if b, err := os.ReadFile(path); err != nil || json.Unmarshal(b, &resumed) != nil || len(resumed.Blocks) != 3 {
+			t.Errorf("%q: got %d blocks recorded after resuming, want 3", flags, len(resumed.Blocks))
+		}
+
+		if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+			t.Errorf("%q: got %v in the checkpoint directory, want only the checkpoint", flags, entries)
+		}
+
+		// The recorded blocks are read back instead of being decoded again.
+		resumed.Blocks[offsets[0]] = []checkpointStation{{Station: stations.Station{Name: "記録駅", NameKana: "きろく", NameEn: "Recorded"}}}
+
+		b, err = json.Marshal(resumed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, _, err := runMain(t, args...); err != nil || !strings.Contains(got, "記録駅\tきろく\tRecorded\t") {
+			t.Errorf("%q: got\n%s\n%v\nwant the recorded station", flags, got, err)
+		}
+	}
+}
+
+func TestCheckpointSource(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	args := []string{"-d", dump, "-i", index, "-checkpoint", path}
+
+	if _, _, err := runMain(t, args...); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := runMain(t, append(args, "-pattern", `\* (\w+) / (\S+) / (\S+)`)...); exitCode(err) != 2 {
+		t.Errorf("other -pattern: got %v, want an input error", err)
+	}
+
+	// The same dump written again may hold other blocks at the offsets.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(dump, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := runMain(t, args...); exitCode(err) != 2 {
+		t.Errorf("dump modified: got %v, want an input error", err)
+	}
+}
+
+func TestIndexOnly(t *testing.T) {
+	// The dump is never read, so it does not have to exist.
+	got, _, err := runMain(t, "-d", filepath.Join(t.TempDir(), "missing.xml.bz2"), "-i", fixtureIndex, "-index-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "id\ttitle\toffset\n" +
+		"20\tList of railway stations in Japan: A\t194\n" +
+		"22\tList of railway stations in Japan: E\t194\n" +
+		"40\tList of railway stations in Japan: B\t804\n" +
+		"41\tList of railway stations in Japan: C\t804\n" +
+		"45\tList of railway stations in Japan: D\t1153\n" +
+		"61\tList of railway stations in Japan: F\t1695\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTitleSelection(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		prefixes []string
+		regexp   string
+		want     []string
+	}{
+		{"default", nil, "", []string{"20", "22", "40", "41", "45", "61"}},
+		{"prefixes", []string{"Zzz", "Chiba"}, "", []string{"50", "60"}},
+		{"regexp", nil, `: [AD]$|^Akabane`, []string{"20", "45", "51"}},
+		{"both", []string{"Other"}, `: F$`, []string{"10", "61"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args := []string{"-d", fixtureDump, "-i", fixtureIndex, "-index-only", "-title-regexp", tt.regexp}
+			for _, prefix := range tt.prefixes {
+				args = append(args, "-title-prefix", prefix)
+			}
+
+			stdout, _, err := runMain(t, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(stdout), "\n")[1:] {
+				got = append(got, strings.Split(line, "\t")[0])
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTitleRegexpLastBlock(t *testing.T) {
+	// F is in the last block, after a page that is not selected.
+	stdout, _, err := runMain(t, "-d", fixtureDump, "-i", fixtureIndex, "-title-regexp", `: F$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Count(stdout, "Fuchū"); got != 1 {
+		t.Errorf("got\n%s\nwant the Fuchū of F", stdout)
+	}
+}
+
+func TestMinStations(t *testing.T) {
+	stdout, stderr, err := runMain(t, "-d", fixtureDump, "-i", fixtureIndex, "-min-stations", "3", "-verbose")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// D and F have two stations and one.
+	for _, name := range []string{"Daikanyama", "Dōgo Onsen", "Fuchū"} {
+		if strings.Contains(stdout, name) {
+			t.Errorf("got %s in\n%s", name, stdout)
+		}
+	}
+
+	if !strings.Contains(stdout, "Chiba-minato") {
+		t.Errorf("got\n%s\nwant the three stations of C kept", stdout)
+	}
+
+	if want := `Fuchū (ふちゅう): page "List of railway stations in Japan: F" has only 1 stations`; !strings.Contains(stderr, want) {
+		t.Errorf("got\n%s\nwant %q", stderr, want)
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	// Break the XML of the block of B and C, keeping the offsets.
+	b, err := os.ReadFile(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = bytes.Replace(b, []byte("Japan: B</title>"), []byte("Japan: B</titel>"), 1)
+	if err := os.WriteFile(dump, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index); err == nil {
+		t.Fatal("got no error without -keep-going")
+	}
+
+	stdout, stderr, err := runMain(t, "-d", dump, "-i", index, "-keep-going")
+	if got := exitCode(err); got != 3 {
+		t.Errorf("got exit code %d (%v), want 3", got, err)
+	}
+
+	if !strings.Contains(stdout, "Abiko") || !strings.Contains(stdout, "Daikanyama") {
+		t.Errorf("got\n%s\nwant the stations of the other blocks", stdout)
+	}
+
+	if strings.Contains(stdout, "Banda") {
+		t.Errorf("got\n%s\nwant none of the broken block", stdout)
+	}
+
+	if !strings.Contains(stderr, "block at ") {
+		t.Errorf("got\n%s\nwant the broken block reported", stderr)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	_, got, err := runMain(t, "-d", dump, "-i", index, "-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "valid stations: 10\ninvalid stations (non-kana characters in name_kana): 1\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	run := func(seed int64) string {
+		stdout, _, err := runMain(t, "-d", dump, "-i", index, "-limit", "4", "-shuffle", "-seed", fmt.Sprint(seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return stdout
+	}
+
+	got := run(1)
+	if again := run(1); again != got {
+		t.Errorf("got\n%s\nthen\n%s\nwant the same sample for the same seed", got, again)
+	}
+
+	if other := run(2); other == got {
+		t.Errorf("got\n%s\nfor seeds 1 and 2, want different samples", got)
+	}
+
+	// The sample keeps the order of the stations, with the header first.
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got\n%s\nwant 4 stations", got)
+	}
+
+	all := strings.Split(strings.TrimSuffix(testTSV, "\n"), "\n")
+	if lines[0] != all[0] {
+		t.Errorf("got header %q, want %q", lines[0], all[0])
+	}
+
+	for i, j := 1, 1; i < len(lines); i++ {
+		for j < len(all) && all[j] != lines[i] {
+			j++
+		}
+
+		if j == len(all) {
+			t.Errorf("got\n%s\nwant stations of\n%s\nin its order", got, testTSV)
+			break
+		}
+
+		j++
+	}
+}
+
+func TestGzipOutput(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	for _, tt := range []struct {
+		format string
+		output string
+	}{
+		{"tsv", ""},
+		{"tsv", "stations.tsv.gz"},
+		{"json", ""},
+		{"json", "stations.json.gz"},
+	} {
+		want, _, err := runMain(t, "-d", dump, "-i", index, "-format", tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args := []string{"-d", dump, "-i", index, "-format", tt.format, "-gzip"}
+		output := ""
+		if tt.output != "" {
+			output = filepath.Join(t.TempDir(), tt.output)
+			args = append(args, "-output", output)
+		}
+
+		stdout, _, err := runMain(t, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		compressed := []byte(stdout)
+		if output != "" {
+			b, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compressed = b
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("%s to %q: %v", tt.format, tt.output, err)
+		}
+
+		// ReadAll fails without the footer of the gzip stream.
+		got, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("%s to %q: %v", tt.format, tt.output, err)
+		}
+
+		if string(got) != want {
+			t.Errorf("%s to %q: got\n%s\nwant\n%s", tt.format, tt.output, got, want)
+		}
+	}
+
+	output := filepath.Join(t.TempDir(), "stations.db")
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-format", "sqlite", "-gzip", "-output", output); err == nil {
+		t.Error("got no error for -gzip with the sqlite format")
+	}
+}
+
+func TestInterrupted(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	b, err := os.ReadFile(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The dump is cut after the page of A, whose stations are the partial
+	// output then.
+	cut := bytes.Index(b, []byte("  <page>\n    <title>Unrelated</title>"))
+
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer stdinR.Close()
+
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer stderrR.Close()
+
+	opts, stdout, _ := testOptions("-", index)
+	opts.Stream, opts.Progress = true, true
+	opts.Stdin, opts.Stderr = stdinR, stderrW
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		stdinW.Write(b[:cut])
+
+		// The progress is reported once the stations of A are extracted,
+		// and the rest of the dump only comes after the interrupt.
+		r := bufio.NewReader(stderrR)
+		if _, err := r.ReadString('\n'); err == nil {
+			cancel()
+		}
+
+		stdinW.Write(b[cut:])
+		stdinW.Close()
+		io.Copy(io.Discard, r)
+	}()
+
+	err = RunContext(ctx, opts)
+	stderrW.Close()
+
+	if got := exitCode(err); got != 130 {
+		t.Errorf("got exit code %d (%v), want 130", got, err)
+	}
+
+	if want := strings.Join(strings.SplitAfter(testTSV, "\n")[:4], ""); stdout.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", stdout, want)
+	}
+}
+
+func TestColumnsFlag(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	stdout, _, err := runMain(t, "-d", dump, "-i", index, "-columns", "name_kana,name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.SplitN(stdout, "\n", 3); got[0] != "name_kana\tname" || got[1] != "あびこ\t我孫子駅" {
+		t.Errorf("got\n%s\nwant the kana and the name only", stdout)
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-columns", "name,kana"); err == nil || !strings.Contains(err.Error(), `unknown column "kana"`) {
+		t.Errorf("got %v, want an unknown column", err)
+	}
+}
+
+func TestNormalizeSuffix(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-normalize-suffix")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Banda Station is merged into Banda, but Dōgo Onsen Station has nothing
+	// to merge with.
+	if want := strings.Replace(testTSV, "番田駅\tばんだ\tBanda Station\t\t\t\n", "", 1); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// recordingHandler keeps the records it handles, for the logs of run.
+type recordingHandler struct {
+	level   slog.Leveler
+	records *[]slog.Record
+}
+
+func (h recordingHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	*h.records = append(*h.records, r)
+	return nil
+}
+
+func (h recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h recordingHandler) WithGroup(string) slog.Handler { return h }
+
+func TestLogLevel(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	var records []slog.Record
+	defer func(f func(io.Writer, *slog.HandlerOptions) slog.Handler) { newLogHandler = f }(newLogHandler)
+	newLogHandler = func(_ io.Writer, opts *slog.HandlerOptions) slog.Handler {
+		return recordingHandler{opts.Level, &records}
+	}
+
+	b, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offsets := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		offsets[fields[2]] = fields[0]
+	}
+
+	for _, tt := range []struct {
+		level string
+		want  []string
+	}{
+		{"warn", nil},
+		{"debug", []string{
+			"decoded block offset=" + offsets["List of railway stations in Japan: A"] + " pages=2 stations=3",
+			"skipped redirect title=List of railway stations in Japan: E",
+			"decoded block offset=" + offsets["List of railway stations in Japan: B"] + " pages=2 stations=7",
+			"decoded block offset=" + offsets["List of railway stations in Japan: D"] + " pages=1 stations=2",
+		}},
+	} {
+		records = nil
+
+		if _, _, err := runMain(t, "-d", dump, "-i", index, "-log-level", tt.level); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, r := range records {
+			if r.Level != slog.LevelDebug || r.Message == "rejected station" {
+				continue
+			}
+
+			s := r.Message
+			r.Attrs(func(a slog.Attr) bool {
+				s += " " + a.String()
+				return true
+			})
+			got = append(got, s)
+		}
+
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("-log-level %s: got %q, want %q", tt.level, got, tt.want)
+		}
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-log-level", "loud"); err == nil {
+		t.Error("got no error for an unknown log level")
+	}
+}
+
+func TestMultipleFormats(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	dir := t.TempDir()
+	stdout, _, err := runMain(t, "-d", dump, "-i", index, "-format", "tsv,json", "-o", filepath.Join(dir, "stations.{ext}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout != "" {
+		t.Errorf("got %q on stdout, want nothing", stdout)
+	}
+
+	for _, format := range []string{"tsv", "json"} {
+		want, _, err := runMain(t, "-d", dump, "-i", index, "-format", format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b, err := os.ReadFile(filepath.Join(dir, "stations."+format)); err != nil || string(b) != want {
+			t.Errorf("%s: got %q, %v, want %q", format, b, err, want)
+		}
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-format", "tsv,json"); err == nil {
+		t.Error("got no error for several formats without {ext}")
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	for _, tt := range []struct {
+		key  string
+		want string
+	}{
+		{"name_en", strings.Replace(strings.Replace(testTSV, "赤羽駅\tあかばね\tAkabane\tTokyo\tJR East\tSaikyō Line\n", "", 1), "赤羽駅\tあかばね\tAkabane\t\t\t\n", "", 1)},
+		{"name_en,line", testTSV},
+	} {
+		got, _, err := runMain(t, "-d", dump, "-i", index, "-dedup-key", tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("-dedup-key %s: got\n%s\nwant\n%s", tt.key, got, tt.want)
+		}
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-dedup-key", "name_en,kana"); err == nil {
+		t.Error("got no error for an unknown field")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	// The earlier output lacks all but the first three stations and has one
+	// removed since.
+	lines := strings.SplitAfter(testTSV, "\n")
+	previous := filepath.Join(t.TempDir(), "previous.tsv")
+	if err := os.WriteFile(previous, []byte(strings.Join(lines[:4], "")+"廃駅\tはいえき\tHaieki\t\t\t\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-diff", previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "-\t廃駅\tはいえき\tHaieki\t\t\t\n"
+	for _, line := range lines[4 : len(lines)-1] {
+		want += "+\t" + line
+	}
+
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-diff", filepath.Join(t.TempDir(), "missing.tsv")); exitCode(err) != 2 {
+		t.Errorf("got %v, want an input error for a missing earlier output", err)
+	}
+}
+
+func TestFailIfEmpty(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	for _, tt := range []struct {
+		titlePrefix string
+		failIfEmpty bool
+		minRows     int
+		wantErr     bool
+	}{
+		{"Other page", false, 0, false},
+		{"Other page", true, 0, true},
+		{"", true, 0, false},
+		{"", false, 11, false},
+		{"", false, 12, true},
+	} {
+		args := []string{"-d", dump, "-i", index, "-min-rows", strconv.Itoa(tt.minRows)}
+		if tt.titlePrefix != "" {
+			args = append(args, "-title-prefix", tt.titlePrefix)
+		}
+		if tt.failIfEmpty {
+			args = append(args, "-fail-if-empty")
+		}
+
+		stdout, _, err := runMain(t, args...)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: got %v, want error %v", tt, err, tt.wantErr)
+		}
+
+		if err != nil && stdout != "" {
+			t.Errorf("%+v: got %q, want nothing written on failing", tt, stdout)
+		}
+	}
+}
+
+func TestExcludeRegexp(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	got, stderr, err := runMain(t, "-d", dump, "-i", index, "-exclude-regexp", ` Station$`, "-verbose")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Replace(strings.Replace(testTSV, "番田駅\tばんだ\tBanda Station\t\t\t\n", "", 1), "道後温泉駅\tどうごおんせん\tDōgo Onsen Station\tEhime\t\t\n", "", 1)
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if !strings.Contains(stderr, "Dōgo Onsen Station (どうごおんせん): name_en matches  Station$") {
+		t.Errorf("got\n%s\nwant the excluded stations reported", stderr)
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-exclude-regexp", `.`, "-exclude-field", "kana"); err == nil {
+		t.Error("got no error for an unknown -exclude-field")
+	}
+}
+
+func TestNormalizeKanaFlag(t *testing.T) {
+	dump, index := writeDump(t, ".xml", [][]testPage{{{80, "List of railway stations in Japan: G", `|[[Gaienmae Station|Gaienmae]] ||[[:ja:外苑前駅|外苑前駅]]（ガイエンマエ）
+|[[Gaienmae Station|Gaienmae]] ||[[:ja:外苑前駅|外苑前駅]]（ｶﾞｲｴﾝﾏｴ）`}}})
+
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-normalize-kana")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "name\tname_kana\tname_en\tprefecture\toperator\tline\n外苑前駅\tガイエンマエ\tGaienmae\t\t\t\n"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPretty(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	// Stdout is a file, not a terminal, so -pretty alone writes TSV.
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-pretty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != testTSV {
+		t.Errorf("got\n%s\nwant\n%s", got, testTSV)
+	}
+
+	got, _, err = runMain(t, "-d", dump, "-i", index, "-pretty-force")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.SplitN(got, "\n", 3)[1]; got != "我孫子駅      あびこ          Abiko               Chiba       JR East   Jōban Line" {
+		t.Errorf("got %q, want the columns aligned", got)
+	}
+}
+
+func TestWikidata(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-wikidata", "-wikidata-dump", "stations/testdata/sitelinks.tsv", "-columns", "name_en,wikidata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"Abiko\tQ1193537\n", "Akabane\tQ801188\n", "Chiba\tQ5372905\n", "Daikanyama\t\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got\n%s\nwant %q", got, want)
+		}
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-wikidata"); err == nil {
+		t.Error("got no error for -wikidata without -wikidata-dump")
+	}
+}
+
+func TestHeaderMap(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	stdout, _, err := runMain(t, "-d", dump, "-i", index, "-columns", "name_en,name_kana", "-header-map", "name_kana=Kana, name_en=English")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.SplitN(stdout, "\n", 3); got[0] != "English\tKana" || got[1] != "Abiko\tあびこ" {
+		t.Errorf("got\n%s\nwant the renamed columns in order", stdout)
+	}
+
+	for _, headerMap := range []string{"kana=Kana", "name_kana"} {
+		if _, _, err := runMain(t, "-d", dump, "-i", index, "-header-map", headerMap); err == nil {
+			t.Errorf("-header-map %s: got no error", headerMap)
+		}
+	}
+}
+
+func TestExtractStationsTimeout(t *testing.T) {
+	pages := []stations.Page{{Title: "A"}, {Title: "Slow"}, {Title: "B"}}
+
+	// The slow page is matched until the test is done, long past the timeout.
+	release := make(chan struct{})
+	defer close(release)
+
+	extract := func(pages []stations.Page, _ []*stations.Pattern) []stations.Station {
+		var ss []stations.Station
+		for _, p := range pages {
+			if p.Title == "Slow" {
+				<-release
+			}
+			ss = append(ss, stations.Station{NameEn: p.Title})
+		}
+
+		return ss
+	}
+
+	var log bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&log, nil))
+
+	ss := extractStationsWith(extract, pages, nil, 50*time.Millisecond, logger)
+	if len(ss) != 2 || ss[0].NameEn != "A" || ss[1].NameEn != "B" {
+		t.Errorf("got %+v, want A and B", ss)
+	}
+
+	if !strings.Contains(log.String(), "skipped page taking too long to match") || !strings.Contains(log.String(), "title=Slow") {
+		t.Errorf("got log %q, want the slow page logged", &log)
+	}
+
+	// Without a timeout, every page is matched at once.
+	fast := func(pages []stations.Page, _ []*stations.Pattern) []stations.Station {
+		return []stations.Station{{NameEn: fmt.Sprint(len(pages))}}
+	}
+	if ss := extractStationsWith(fast, pages, nil, 0, logger); len(ss) != 1 || ss[0].NameEn != "3" {
+		t.Errorf("got %+v, want the pages matched together", ss)
+	}
+}
+
+func TestDupStats(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	_, stderr, err := runMain(t, "-d", dump, "-i", index, "-dup-stats", "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "stations before deduplication: 12\nstations after deduplication: 11\n2\tBanda Station (ばんだ)\n"; stderr != want {
+		t.Errorf("got\n%s\nwant\n%s", stderr, want)
+	}
+}
+
+func TestMultipleDumps(t *testing.T) {
+	blocks := testBlocks
+	dump, index := writeDump(t, ".xml", blocks)
+
+	// The block of A is in both dumps, and its stations once in the output.
+	dump1, index1 := writeDump(t, ".xml", blocks[:3])
+	dump2, index2 := writeDump(t, ".gz", append([][]testPage{blocks[1]}, blocks[3:]...))
+
+	for _, args := range [][]string{nil, {"-coords"}} {
+		want, _, err := runMain(t, append([]string{"-d", dump, "-i", index}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, _, err := runMain(t, append([]string{"-d", dump1 + "," + dump2, "-i", index1 + "," + index2}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("%q: got\n%s\nwant\n%s", args, got, want)
+		}
+	}
+
+	if _, _, err := runMain(t, "-d", dump1+","+dump2, "-i", index1); err == nil {
+		t.Error("got no error for more dumps than indexes")
+	}
+}
+
+func TestSortPrefecture(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-sort", "prefecture", "-columns", "prefecture,name_en,line")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "prefecture\tname_en\tline\n" +
+		"Chiba\tAbiko\tJōban Line\n" +
+		"Ehime\tDōgo Onsen Station\t\n" +
+		"Tokyo\tAkabane\tKeihin-Tōhoku Line\n" +
+		"Tokyo\tAkabane\tSaikyō Line\n" +
+		"Tokyo\tDaikanyama\tTōyoko Line\n" +
+		"\tAkabane\t\n" +
+		"\tBanda\t\n" +
+		"\tBanda Station\t\n" +
+		"\tChiba\t\n" +
+		"\tChiba-minato\t\n" +
+		"\tchiba\t\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	b, err := os.ReadFile(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The dump date comes from a dump named the way Wikimedia does, and from
+	// -date otherwise.
+	named := filepath.Join(t.TempDir(), "enwiki-20240101-pages-articles-multistream.xml")
+	if err := os.WriteFile(named, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-d", named, "-i", index}, fmt.Sprintf("# schema_version=%d generated_from=20240101\nname\t", stations.SchemaVersion)},
+		{[]string{"-d", dump, "-i", index, "-date", "20230101"}, fmt.Sprintf("# schema_version=%d generated_from=20230101\nname\t", stations.SchemaVersion)},
+		{[]string{"-d", named, "-i", index, "-format", "json"}, fmt.Sprintf(`{"schema_version":%d,"generated_from":"20240101","stations":[{"name":"我孫子駅",`, stations.SchemaVersion)},
+	} {
+		got, _, err := runMain(t, append(tt.args, "-schema-version")...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%q: got\n%s\nwant it to start with %q", tt.args, got, tt.want)
+		}
+
+		// The earlier output with the schema version reads back the same.
+		previous := filepath.Join(t.TempDir(), "previous")
+		if err := os.WriteFile(previous, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		diff, _, err := runMain(t, "-d", dump, "-i", index, "-diff", previous)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff != "" {
+			t.Errorf("%q: got the diff\n%s\nwant none", tt.args, diff)
+		}
+	}
+}
+
+func TestFailOnMissing(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	// Z is indexed in the block of A but not in it.
+	b, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var offset string
+	var lines []string
+	for _, line := range strings.SplitAfter(string(b), "\n") {
+		lines = append(lines, line)
+		if o, rest, _ := strings.Cut(line, ":"); strings.HasPrefix(rest, "20:") {
+			offset = o
+			lines = append(lines, offset+":99:List of railway stations in Japan: Z\n")
+		}
+	}
+
+	if err := os.WriteFile(index, []byte(strings.Join(lines, "")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, err := runMain(t, "-d", dump, "-i", index, "-verbose")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout != testTSV {
+		t.Errorf("got\n%s\nwant\n%s", stdout, testTSV)
+	}
+
+	if want := "List of railway stations in Japan: Z (99): not in the block at " + offset + "\n"; !strings.Contains(stderr, want) {
+		t.Errorf("got\n%s\nwant %q", stderr, want)
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-fail-on-missing"); exitCode(err) != 3 {
+		t.Errorf("got exit code %d (%v), want 3", exitCode(err), err)
+	}
+}
+
+func TestRomanizeFlag(t *testing.T) {
+	dump, index := writeDump(t, ".xml", [][]testPage{{{80, "List of railway stations in Japan: T", "*  / 東京駅 / とうきょう\n* Shinagawa / 品川駅 / しながわ"}}})
+
+	// The first line has no English name.
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-pattern", `\* (\w*) / (\S+) / (\S+)`, "-romanize", "-columns", "name,name_en,name_en_derived")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "name\tname_en\tname_en_derived\n品川駅\tShinagawa\t\n東京駅\tTōkyō\ttrue\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestKeepDisambiguation(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-keep-disambiguation")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "name\tname_kana\tname_en\tdisambiguation\tprefecture\toperator\tline\n我孫子駅\tあびこ\tAbiko\tChiba\tChiba\tJR East\tJōban Line\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("got\n%s\nwant it to start with\n%s", got, want)
+	}
+}
+
+func TestOnlyAdded(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	// The baseline lacks Banda and Chiba-minato, and has a station since
+	// removed, which -only-added leaves out.
+	banda := "番田駅\tばんだ\tBanda\t\t\t\n"
+	minato := "千葉みなと駅\tちばminato\tChiba-minato\t\t\t\n"
+	baseline := strings.Replace(strings.Replace(testTSV, banda, "", 1), minato, "", 1) + "五反田駅\tごたんだ\tGotanda\tTokyo\tJR East\tYamanote Line\t\t\n"
+
+	previous := filepath.Join(t.TempDir(), "previous.tsv")
+	if err := os.WriteFile(previous, []byte(baseline), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-diff", previous, "-only-added")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "name\tname_kana\tname_en\tprefecture\toperator\tline\n" + banda + minato; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-only-added"); err == nil {
+		t.Error("got no error for -only-added without -diff")
+	}
+}
+
+func TestWithRaw(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	stdout, _, err := runMain(t, "-d", dump, "-i", index, "-with-raw", "-format", "ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `"raw":"|[[Daikanyama Station|Daikanyama]]\n|[[:ja:代官山駅|代官山駅]]（だいかんやま）\n|[[Tokyo]]\n|Tokyu\n|[[Tōyoko Line]]"`; !strings.Contains(stdout, want) {
+		t.Errorf("got\n%s\nwant %s", stdout, want)
+	}
+
+	// Off by default, and the same stations either way.
+	plain, _, err := runMain(t, "-d", dump, "-i", index, "-format", "ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(plain, `"raw"`) {
+		t.Errorf("got\n%s\nwant no raw", plain)
+	}
+
+	if got, want := strings.Count(stdout, "\n"), strings.Count(plain, "\n"); got != want {
+		t.Errorf("got %d stations, want %d", got, want)
+	}
+}
+
+func TestCountByFlag(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+	want := "\t7\nJR East\t3\nTokyu\t1\n"
+
+	stdout, stderr, err := runMain(t, "-d", dump, "-i", index, "-count-by", "operator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stderr != want {
+		t.Errorf("got\n%s\nwant\n%s", stderr, want)
+	}
+
+	if stdout != "" {
+		t.Errorf("got\n%s\nwant no stations", stdout)
+	}
+
+	stdout, stderr, err = runMain(t, "-d", dump, "-i", index, "-count-by", "operator", "-count-by-and-rows")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stderr != want || stdout != testTSV {
+		t.Errorf("got\n%s\nand\n%s\nwant the counts and the stations", stderr, stdout)
+	}
+}
+
+func TestMaxIndexLine(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	stdout, _, err := runMain(t, "-d", dump, "-i", index, "-read-buffer", "16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout != testTSV {
+		t.Errorf("got\n%s\nwant\n%s", stdout, testTSV)
+	}
+
+	_, _, err = runMain(t, "-d", dump, "-i", index, "-max-index-line", "10")
+	if err == nil || !strings.Contains(err.Error(), "longer than 10 bytes") {
+		t.Errorf("got %v, want an error naming the limit", err)
+	}
+}
+
+func TestDumpMatchedTitles(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+	want := `List of railway stations in Japan: A
+List of railway stations in Japan: B
+List of railway stations in Japan: C
+List of railway stations in Japan: D
+List of railway stations in Japan: E
+`
+
+	stdout, stderr, err := runMain(t, "-d", dump, "-i", index, "-dump-matched-titles", "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stderr != want {
+		t.Errorf("got\n%s\nwant\n%s", stderr, want)
+	}
+
+	if stdout != testTSV {
+		t.Errorf("got\n%s\nwant\n%s", stdout, testTSV)
+	}
+
+	name := filepath.Join(t.TempDir(), "titles.txt")
+
+	if _, stderr, err = runMain(t, "-d", dump, "-i", index, "-dump-matched-titles", name); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != want || stderr != "" {
+		t.Errorf("got\n%s\nand\n%s\nwant\n%s\nin the file only", got, stderr, want)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing", "titles.txt")
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-dump-matched-titles", missing); exitCode(err) != 4 {
+		t.Errorf("got %v, want a write error", err)
+	}
+}
+
+func TestNormalizeWhitespaceFlag(t *testing.T) {
+	// The rows differ only in whitespace, a tab in name_kana among them.
+	dump, index := writeDump(t, ".xml", [][]testPage{{
+		{70, "List of railway stations in Japan: O", "|[[Ōmiya-kōen Station|Ōmiya\u00a0 kōen]] ||[[:ja:大宮公園駅|大宮公園駅]]（おおみや\tこうえん） || [[Saitama Prefecture|Saitama]]\u00a0\n|[[Ōmiya-kōen Station|Ōmiya kōen]] ||[[:ja:大宮公園駅|大宮公園駅]]（おおみや\u00a0こうえん） || Saitama"},
+	}})
+
+	stdout, _, err := runMain(t, "-d", dump, "-i", index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Count(stdout, "\n"); got != 3 {
+		t.Errorf("got\n%s\nwant both rows without the flag", stdout)
+	}
+
+	stdout, _, err = runMain(t, "-d", dump, "-i", index, "-normalize-whitespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "name\tname_kana\tname_en\tprefecture\toperator\tline\n大宮公園駅\tおおみや こうえん\tŌmiya kōen\tSaitama\t\t\n"
+	if stdout != want {
+		t.Errorf("got\n%q\nwant\n%q", stdout, want)
+	}
+}
+
+func TestOnlyTitle(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	stdout, _, err := runMain(t, "-d", dump, "-i", index, "-only-title", "List of railway stations in Japan: C")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "name\tname_kana\tname_en\tprefecture\toperator\tline\n千葉駅\tちば\tChiba\t\t\t\n千葉みなと駅\tちばminato\tChiba-minato\t\t\t\n千葉駅\tちば\tchiba\t\t\t\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	// Only the page is decoded, not B in the same block.
+	_, stderr, err := runMain(t, "-d", dump, "-i", index, "-only-title", "List of railway stations in Japan: C", "-count")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "matched index entries: 1\ndecoded pages: 1\nstation matches: 3\nunique stations: 3\n"; stderr != want {
+		t.Errorf("got\n%s\nwant\n%s", stderr, want)
+	}
+
+	_, _, err = runMain(t, "-d", dump, "-i", index, "-only-title", "List of railway stations in Japan: Z")
+	if got := exitCode(err); got != 2 {
+		t.Errorf("got exit code %d for a page not in the index, want 2", got)
+	}
+}
+
+func TestRejectFile(t *testing.T) {
+	blocks := append(slices.Clone(testBlocks), []testPage{{70, "List of railway stations in Japan: O", "|[[Ōmiya Station (Saitama)|Ōmiya]] ||[[:ja:大宮駅 (埼玉県)|大宮駅]]（おおみや） || Saitama\n|[[Ōji Station|Ōji]] ||[[:ja:王子駅|王子駅]]（王子） || Tokyo"}})
+	dump, index := writeDump(t, ".xml", blocks)
+	name := filepath.Join(t.TempDir(), "rejects.tsv")
+
+	stdout, _, err := runMain(t, "-d", dump, "-i", index, "-reject-file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "name\tname_kana\tname_en\tprefecture\toperator\tline\treason\n千葉みなと駅\tちばminato\tChiba-minato\t\t\t\tnon-kana characters in name_kana\n王子駅\t王子\tŌji\tTokyo\t\t\tnon-kana characters in name_kana\n"
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// The valid stations alone go to the output.
+	want = strings.Replace(testTSV, "千葉みなと駅\tちばminato\tChiba-minato\t\t\t\n", "", 1) + "大宮駅\tおおみや\tŌmiya\tSaitama\t\t\n"
+	if stdout != want {
+		t.Errorf("got\n%s\nwant\n%s", stdout, want)
+	}
+}
+
+// singleStreamDump gzips the uncompressed dump as a whole, as the dumps that
+// are not multistream are compressed, returning its path.
+func singleStreamDump(t *testing.T, dump string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d bytes.Buffer
+	zw := gzip.NewWriter(&d)
+	zw.Write(b)
+	zw.Close()
+
+	name := filepath.Join(t.TempDir(), "pages-articles.xml.gz")
+	if err := os.WriteFile(name, d.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+func TestSingleStream(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	// The offsets of the index are those of the multistream dump, not of
+	// this one.
+	stdout, _, err := runMain(t, "-d", singleStreamDump(t, dump), "-i", index, "-single-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout != testTSV {
+		t.Errorf("got\n%s\nwant\n%s", stdout, testTSV)
+	}
+
+	// The pages are matched by the IDs of the index.
+	chiba := filepath.Join(t.TempDir(), "index.txt")
+	if err := os.WriteFile(chiba, []byte("100:41:List of railway stations in Japan: C\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, err = runMain(t, "-d", singleStreamDump(t, dump), "-i", chiba, "-single-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "name\tname_kana\tname_en\tprefecture\toperator\tline\n千葉駅\tちば\tChiba\t\t\t\n千葉みなと駅\tちばminato\tChiba-minato\t\t\t\n千葉駅\tちば\tchiba\t\t\t\n"
+	if stdout != want {
+		t.Errorf("got\n%s\nwant\n%s", stdout, want)
+	}
+}
+
+func TestSingleStreamNoIndex(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	for _, tt := range []struct {
+		name, dump, index string
+	}{
+		{"test dump", singleStreamDump(t, dump), index},
+		{"fixture", fixtureDump, fixtureIndex},
+	} {
+		want, _, err := runMain(t, "-d", tt.dump, "-i", tt.index, "-single-stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The titles are matched while scanning without the index.
+		got, _, err := runMain(t, "-d", tt.dump, "-single-stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("%s: got\n%s\nwant the stations of the index\n%s", tt.name, got, want)
+		}
+	}
+
+	if _, _, err := runMain(t, "-d", singleStreamDump(t, dump), "-single-stream", "-index-only"); err == nil {
+		t.Error("got no error for -index-only without an index")
+	}
+}
+
+func TestSortNone(t *testing.T) {
+	// The order of the pages and their rows, without the duplicates.
+	want := `name	name_kana	name_en	prefecture	operator	line
+赤羽駅	あかばね	Akabane	Tokyo	JR East	Keihin-Tōhoku Line
+赤羽駅	あかばね	Akabane	Tokyo	JR East	Saikyō Line
+我孫子駅	あびこ	Abiko	Chiba	JR East	Jōban Line
+番田駅	ばんだ	Banda Station			
+赤羽駅	あかばね	Akabane			
+番田駅	ばんだ	Banda			
+千葉駅	ちば	Chiba			
+千葉みなと駅	ちばminato	Chiba-minato			
+千葉駅	ちば	chiba			
+代官山駅	だいかんやま	Daikanyama	Tokyo	Tokyu	Tōyoko Line
+道後温泉駅	どうごおんせん	Dōgo Onsen Station	Ehime		
+`
+
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	for _, jobs := range []string{"1", "4"} {
+		stdout, _, err := runMain(t, "-d", dump, "-i", index, "-sort", "none", "-jobs", jobs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stdout != want {
+			t.Errorf("-jobs %s: got\n%s\nwant\n%s", jobs, stdout, want)
+		}
+	}
+}
+
+func TestDisambig(t *testing.T) {
+	dump, index := writeDump(t, ".xml", [][]testPage{{{80, "List of railway stations in Japan: F", "|[[Foo (A) Bar Station|Foo (A) Bar (B)]] ||[[:ja:フー駅|フー駅]]（ふう）"}}})
+
+	for _, tt := range []struct {
+		flags []string
+		want  string
+	}{
+		{nil, "Foo"},
+		{[]string{"-disambig", "first"}, "Foo"},
+		{[]string{"-disambig", "trailing"}, "Foo (A) Bar"},
+		{[]string{"-disambig-regexp", `\s*\(B\)`}, "Foo (A) Bar"},
+		{[]string{"-no-disambig"}, "Foo (A) Bar (B)"},
+	} {
+		got, _, err := runMain(t, append([]string{"-d", dump, "-i", index, "-columns", "name_en"}, tt.flags...)...)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.flags, err)
+		}
+
+		if got != "name_en\n"+tt.want+"\n" {
+			t.Errorf("%q: got %q, want %q", tt.flags, got, tt.want)
+		}
+	}
+
+	for _, flags := range [][]string{
+		{"-disambig", "last"},
+		{"-disambig-regexp", "("},
+		{"-disambig", "trailing", "-disambig-regexp", `\(B\)`},
+		{"-disambig", "first", "-disambig-regexp", `\(B\)`},
+		{"-no-disambig", "-keep-disambiguation"},
+	} {
+		if _, _, err := runMain(t, append([]string{"-d", dump, "-i", index}, flags...)...); err == nil {
+			t.Errorf("got no error for %q", flags)
+		}
+	}
+}
+
+func TestGeoJSONFormat(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	stdout, _, err := runMain(t, "-d", dump, "-i", index, "-format", "geojson", "-coords")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties struct {
+				NameEn string `json:"name_en"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &fc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the stations whose articles have coordinates: the three Akabane
+	// and the two Chiba.
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 5 {
+		t.Fatalf("got a %s of %d features, want a FeatureCollection of 5", fc.Type, len(fc.Features))
+	}
+
+	if f := fc.Features[3]; f.Properties.NameEn != "Chiba" || !slices.Equal(f.Geometry.Coordinates, []float64{140.1135, 35.613}) {
+		t.Errorf("got %+v, want Chiba at [140.1135, 35.613]", f)
+	}
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-format", "geojson"); err == nil {
+		t.Error("got no error without -coords")
+	}
+}
+
+func TestCheckUTF8(t *testing.T) {
+	ss := []stations.Station{
+		{Name: "赤羽駅", NameKana: "あかばね", NameEn: "Akabane"},
+		{Name: "番田\xff駅", NameKana: "ばんだ", NameEn: "Banda"},
+	}
+
+	got, err := checkUTF8(ss, "", nil)
+	if err != nil || len(got) != 2 || got[1].Name != "番田\uFFFD駅" {
+		t.Errorf("got %+v, %v, want the invalid UTF-8 replaced", got, err)
+	}
+
+	var dropped []string
+	got, err = checkUTF8(ss, "drop", func(s stations.Station, reason string) { dropped = append(dropped, s.NameEn+": "+reason) })
+	if err != nil || len(got) != 1 || got[0].NameEn != "Akabane" || !slices.Equal(dropped, []string{"Banda: name is not valid UTF-8"}) {
+		t.Errorf("got %+v, %v and dropped %q, want Banda dropped", got, err, dropped)
+	}
+
+	_, err = checkUTF8(ss, "error", nil)
+	if err == nil || err.Error() != "Banda (ばんだ): name is not valid UTF-8" || exitCode(err) != 3 {
+		t.Errorf("got %v, want a parse error naming Banda", err)
+	}
+
+	dump, index := writeDump(t, ".xml", testBlocks)
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-strict-utf8", "warn"); err == nil {
+		t.Error("got no error for an unknown mode")
+	}
+}
+
+func TestOnlyTitleLastBlock(t *testing.T) {
+	stdout, _, err := runMain(t, "-d", fixtureDump, "-i", fixtureIndex, "-only-title", "List of railway stations in Japan: F")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "name\tname_kana\tname_en\tprefecture\toperator\tline\n府中駅\tふちゅう\tFuchū\tTokyo\tKeio\tKeio Line\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+// fuchuBlocks list two stations told apart only by their disambiguation.
+var fuchuBlocks = [][]testPage{{{70, "List of railway stations in Japan: F", `|[[Fuchū Station (Tokyo)|Fuchū (Tokyo)]] ||[[:ja:府中駅 (東京都)|府中駅]]（ふちゅう）
+|[[Fuchū Station (Hiroshima)|Fuchū (Hiroshima)]] ||[[:ja:府中駅 (広島県)|府中駅]]（ふちゅう）`}}}
+
+func TestSQLiteDisambiguation(t *testing.T) {
+	dump, index := writeDump(t, ".xml", fuchuBlocks)
+	output := filepath.Join(t.TempDir(), "stations.db")
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-keep-disambiguation", "-format", "sqlite", "-o", output); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT name, disambiguation FROM stations ORDER BY disambiguation`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var name, disambiguation string
+		if err := rows.Scan(&name, &disambiguation); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name+" ("+disambiguation+")")
+	}
+
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "府中駅 (Hiroshima), 府中駅 (Tokyo)"; strings.Join(got, ", ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, ", "), want)
+	}
+}
+
+func TestSQLiteDedupFields(t *testing.T) {
+	chiba := stations.Station{Name: "千葉駅", NameKana: "ちば", NameEn: "Chiba", Lat: 35.613, Lon: 140.1135}
+	moved, opened := chiba, chiba
+	moved.Lat = 35.6
+	opened.OpenedYear = 1894
+
+	for _, tt := range []struct {
+		name   string
+		dedup  stations.DedupOptions
+		ss     []stations.Station
+		unique string
+		rows   int
+	}{
+		{"default", stations.DedupOptions{}, []stations.Station{chiba, moved}, "name, name_kana, name_en, prefecture, operator, line, code, lat, lon", 2},
+		{"fields", stations.DedupOptions{Fields: []string{"line", "name"}}, []stations.Station{chiba}, "name, line", 1},
+		{"fields not in the table", stations.DedupOptions{Fields: []string{"name", "opened_year"}}, []stations.Station{chiba, opened}, "name", 1},
+		{"disambiguation", stations.DedupOptions{Fields: []string{"name"}, Disambiguation: true}, []stations.Station{chiba}, "name, disambiguation", 1},
+	} {
+		path := filepath.Join(t.TempDir(), "stations.db")
+		if err := writeSQLite(path, tt.ss, stations.OutputOptions{Dedup: tt.dedup}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		var unique string
+		if err := db.QueryRow(`SELECT group_concat(name, ', ') FROM (SELECT name FROM pragma_index_info('stations_unique') ORDER BY seqno)`).Scan(&unique); err != nil {
+			t.Fatal(err)
+		}
+
+		var rows int
+		if err := db.QueryRow(`SELECT count(*) FROM stations`).Scan(&rows); err != nil {
+			t.Fatal(err)
+		}
+
+		if unique != tt.unique || rows != tt.rows {
+			t.Errorf("%s: got a unique index on %q and %d rows, want %q and %d", tt.name, unique, rows, tt.unique, tt.rows)
+		}
+	}
+}
+
+func TestWithIDDisambiguation(t *testing.T) {
+	dump, index := writeDump(t, ".xml", fuchuBlocks)
+
+	stdout, _, err := runMain(t, "-d", dump, "-i", index, "-keep-disambiguation", "-columns", "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 || lines[1] == lines[2] {
+		t.Errorf("got %q, want two distinct ids", lines)
+	}
+}
+
+func TestDiffLimit(t *testing.T) {
+	dump, index := writeDump(t, ".xml", testBlocks)
+
+	// The earlier output has the first stations, which -limit would pick.
+	lines := strings.SplitAfter(testTSV, "\n")
+	previous := filepath.Join(t.TempDir(), "previous.tsv")
+	if err := os.WriteFile(previous, []byte(strings.Join(lines[:4], "")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, err := runMain(t, "-d", dump, "-i", index, "-diff", previous, "-only-added", "-limit", "2", "-columns", "name_en,line")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "name_en\tline\nAkabane\t\nBanda\t\n"; stdout != want {
+		t.Errorf("-only-added -limit 2: got %q, want %q", stdout, want)
+	}
+
+	stdout, _, err = runMain(t, "-d", dump, "-i", index, "-diff", previous, "-limit", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := strings.Count(stdout, "+\t"), strings.Count(testTSV, "\n")-4; got != want {
+		t.Errorf("-diff -limit 2: got %d added, want %d\n%s", got, want, stdout)
+	}
+}
+
+func TestCoordinatesColumns(t *testing.T) {
+	opts, stdout, _ := testOptions(writeDump(t, ".xml", testBlocks))
+	opts.Coords = true
+	runTest(t, opts)
+
+	lines := strings.Split(stdout.String(), "\n")
+	if want := "name\tname_kana\tname_en\tprefecture\toperator\tline\tlat\tlon"; lines[0] != want {
+		t.Errorf("got header %q, want %q", lines[0], want)
+	}
+
+	if !slices.ContainsFunc(lines, func(l string) bool {
+		return strings.HasPrefix(l, "千葉駅\tちば\tChiba\t") && strings.HasSuffix(l, "\t35.613\t140.1135")
+	}) {
+		t.Errorf("got no coordinates for Chiba Station in\n%s", stdout)
+	}
+}
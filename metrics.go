@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// metrics are the numbers of a run written by -metrics-file.
+type metrics struct {
+	start        time.Time
+	stations     int
+	indexEntries int
+	pages        int
+	blocksFailed int
+}
+
+// write writes the metrics in the text format of the Prometheus textfile
+// collector, replacing the file name at once so that it is never scraped half
+// written.
+func (m *metrics) write(name string) error {
+	err := writeFileAtomic(name, 0o644, func(w io.Writer) error {
+		for _, metric := range []struct {
+			name, help string
+			value      any
+		}{
+			{"stations_total", "Number of stations written.", m.stations},
+			{"index_entries_matched", "Number of index entries of the pages extracted from.", m.indexEntries},
+			{"pages_decoded", "Number of pages decoded from the dump.", m.pages},
+			{"blocks_failed", "Number of dump blocks skipped for failing to decode.", m.blocksFailed},
+			{"run_duration_seconds", "Duration of the run in seconds.", time.Since(m.start).Seconds()},
+		} {
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", metric.name, metric.help, metric.name, metric.name, metric.value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+
+	return nil
+}
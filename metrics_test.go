@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMetricsFile(t *testing.T) {
+	dir := t.TempDir()
+
+	dump, index := writeDump(t, ".xml", testBlocks)
+	name := filepath.Join(dir, "railway_stations.prom")
+
+	if _, _, err := runMain(t, "-d", dump, "-i", index, "-metrics-file", name); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var names []string
+	values := make(map[string]float64)
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, " ")
+		if !ok {
+			t.Fatalf("got line %q, want a name and a value", line)
+		}
+
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if v < 0 {
+			t.Errorf("got %s %v, want it non-negative", name, v)
+		}
+
+		names = append(names, name)
+		values[name] = v
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"stations_total", "index_entries_matched", "pages_decoded", "blocks_failed", "run_duration_seconds"}; !slices.Equal(names, want) {
+		t.Errorf("got metrics %q, want %q", names, want)
+	}
+
+	for name, want := range map[string]float64{"stations_total": 11, "index_entries_matched": 5, "pages_decoded": 5, "blocks_failed": 0} {
+		if got := values[name]; got != want {
+			t.Errorf("got %s %v, want %v", name, got, want)
+		}
+	}
+
+	// Nothing is left of the temporary file the metrics were written to.
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("got %v, %v in the directory, want only the metrics file", entries, err)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+type mappedFile []byte
+
+// mmap is not supported here, so the caller always falls back to reading f.
+func mmap(f *os.File) (m mappedFile, ok bool) {
+	return nil, false
+}
+
+func (m mappedFile) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errors.New("mmap is not supported")
+}
+
+func (m mappedFile) Close() error {
+	return nil
+}
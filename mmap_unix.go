@@ -0,0 +1,49 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// mappedFile is an io.ReaderAt over a read-only memory mapping of a file.
+type mappedFile []byte
+
+// mmap maps f into memory, returning ok false if it cannot be mapped so that
+// the caller falls back to reading f.
+func mmap(f *os.File) (m mappedFile, ok bool) {
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 || int64(int(fi.Size())) != fi.Size() {
+		return nil, false
+	}
+
+	b, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, false
+	}
+
+	return b, true
+}
+
+func (m mappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	if off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, m[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (m mappedFile) Close() error {
+	return syscall.Munmap(m)
+}
@@ -0,0 +1,110 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hirofumi/railway-stations-in-japan/stations"
+)
+
+func TestMmap(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "dump.xml")
+	if err := os.WriteFile(name, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	m, ok := mmap(f)
+	if !ok {
+		t.Fatal("got no mapping")
+	}
+
+	defer m.Close()
+
+	r := io.NewSectionReader(m, 3, 4)
+	if b, err := io.ReadAll(r); err != nil || string(b) != "3456" {
+		t.Errorf("got %q, %v, want %q", b, err, "3456")
+	}
+
+	p := make([]byte, 4)
+	if n, err := m.ReadAt(p, 8); n != 2 || err != io.EOF {
+		t.Errorf("got %d, %v past the end, want 2, EOF", n, err)
+	}
+
+	if _, err := m.ReadAt(p, -1); err == nil {
+		t.Error("got no error for a negative offset")
+	}
+}
+
+func TestMmapEmpty(t *testing.T) {
+	// An empty file cannot be mapped, so the file is read instead.
+	name := filepath.Join(t.TempDir(), "dump.xml")
+	if err := os.WriteFile(name, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if _, ok := mmap(f); ok {
+		t.Error("got an empty file mapped")
+	}
+}
+
+func TestMmapFlag(t *testing.T) {
+	dump, index := writeDump(t, ".gz", testBlocks)
+
+	got, _, err := runMain(t, "-d", dump, "-i", index, "-mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != testTSV {
+		t.Errorf("got\n%s\nwant\n%s", got, testTSV)
+	}
+}
+
+func BenchmarkMmap(b *testing.B) {
+	// Many small blocks, each read at its offset, are where the reads of the
+	// file cost the most.
+	var blocks [][]testPage
+	for i := int64(0); i < 2000; i++ {
+		blocks = append(blocks, []testPage{{100 + i, fmt.Sprintf("List of railway stations in Japan: %d", i), testBlocks[1][0].text}})
+	}
+
+	dump, index := writeDump(b, ".gz", blocks)
+
+	in := files{open: os.Open}
+
+	for _, useMmap := range []bool{false, true} {
+		b.Run(fmt.Sprintf("mmap=%v", useMmap), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				idx, err := in.extractIndex(index, func(title []byte) bool { return bytes.HasPrefix(title, []byte(stations.ListPagePrefix)) })
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if err := in.streamPages(context.Background(), dump, idx, 1, useMmap, nil, func(stations.Block) error { return nil }); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/hirofumi/railway-stations-in-japan/stations"
+)
+
+// files reads the input files of a run.
+type files struct {
+	// open opens the files, the index and the dump files among them.
+	open func(string) (*os.File, error)
+	// stdin is read for a dump named -.
+	stdin io.Reader
+	// index configures the reading of the index files.
+	index stations.IndexOptions
+}
+
+// retrying wraps open so that a failure other than a missing file or a denied
+// permission is retried up to retries times, waiting delay before the first
+// retry and twice as long before each following one. Retries are logged to
+// log.
+func retrying(open func(string) (*os.File, error), retries int, delay time.Duration, log io.Writer) func(string) (*os.File, error) {
+	return func(name string) (*os.File, error) {
+		wait := delay
+
+		for attempt := 1; ; attempt++ {
+			f, err := open(name)
+			if err == nil || attempt > retries || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+				return f, err
+			}
+
+			fmt.Fprintf(log, "retrying in %s (%d/%d): %v\n", wait, attempt, retries, err)
+
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// flakyOpener fails with err the first failures times it is called.
+type flakyOpener struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (o *flakyOpener) open(name string) (*os.File, error) {
+	o.calls++
+	if o.calls <= o.failures {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: o.err}
+	}
+
+	return os.Open(name)
+}
+
+func TestRetrying(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "dump.xml")
+	if err := os.WriteFile(name, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	o := flakyOpener{failures: 2, err: syscall.EIO}
+	var log bytes.Buffer
+
+	f, err := retrying(o.open, 3, 0, &log)(name)
+	if err != nil {
+		t.Fatalf("got %v, want opened after two retries", err)
+	}
+
+	f.Close()
+
+	if o.calls != 3 {
+		t.Errorf("got %d calls, want 3", o.calls)
+	}
+
+	if got := strings.Count(log.String(), "retrying in "); got != 2 {
+		t.Errorf("got %d retries logged, want 2:\n%s", got, &log)
+	}
+}
+
+func TestRetryingGivesUp(t *testing.T) {
+	for _, tt := range []struct {
+		err   error
+		calls int
+	}{
+		{syscall.EIO, 3},
+		{fs.ErrNotExist, 1},
+		{fs.ErrPermission, 1},
+	} {
+		t.Run(fmt.Sprint(tt.err), func(t *testing.T) {
+			o := flakyOpener{failures: 10, err: tt.err}
+
+			_, err := retrying(o.open, 2, 0, &bytes.Buffer{})("dump.xml")
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+
+			if o.calls != tt.calls {
+				t.Errorf("got %d calls, want %d", o.calls, tt.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package stations
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ResolveCoordinates fills Lat and Lon of each station from the {{Coord}}
+// template in its own article, looked up by title among pages.
+func ResolveCoordinates(stations []Station, pages []Page) {
+	type coordinate struct{ lat, lon float64 }
+
+	coordinates := make(map[string]coordinate, len(pages))
+	for _, p := range pages {
+		if lat, lon, ok := parseCoord(p.Revision.Text); ok {
+			coordinates[p.Title] = coordinate{lat, lon}
+		}
+	}
+
+	for i, s := range stations {
+		if c, ok := coordinates[s.Article]; ok {
+			stations[i].Lat = c.lat
+			stations[i].Lon = c.lon
+		}
+	}
+}
+
+var coordRegexp = regexp.MustCompile(`(?i){{\s*coord\s*\|([^}]*)}}`)
+
+// parseCoord reads the first {{Coord}} template in text, accepting both the
+// decimal form ({{coord|35.68|139.76}}) and the degrees/minutes/seconds form
+// ({{coord|35|40|52|N|139|46|00|E}}). Named parameters are ignored.
+func parseCoord(text string) (lat, lon float64, ok bool) {
+	m := coordRegexp.FindStringSubmatch(text)
+	if m == nil {
+		return 0, 0, false
+	}
+
+	var args []string
+	for _, a := range strings.Split(m[1], "|") {
+		if a = strings.TrimSpace(a); a != "" && !strings.Contains(a, "=") {
+			args = append(args, a)
+		}
+	}
+
+	var (
+		decimal    []float64
+		hemisphere []float64
+		value      float64
+		scale      = 1.0
+	)
+
+	for _, a := range args {
+		switch strings.ToUpper(a) {
+		case "S", "W":
+			value = -value
+			fallthrough
+		case "N", "E":
+			hemisphere = append(hemisphere, value)
+			value, scale = 0, 1
+			continue
+		}
+
+		f, err := strconv.ParseFloat(a, 64)
+		if err != nil {
+			continue
+		}
+
+		decimal = append(decimal, f)
+		value += f / scale
+		scale *= 60
+	}
+
+	switch {
+	case len(hemisphere) >= 2:
+		lat, lon = hemisphere[0], hemisphere[1]
+	case len(decimal) >= 2:
+		lat, lon = decimal[0], decimal[1]
+	default:
+		return 0, 0, false
+	}
+
+	return lat, lon, lat != 0 || lon != 0
+}
@@ -0,0 +1,45 @@
+package stations
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseCoord(t *testing.T) {
+	for _, tt := range []struct {
+		text     string
+		lat, lon float64
+		ok       bool
+	}{
+		{"{{coord|35.6130|140.1135}}", 35.6130, 140.1135, true},
+		{"{{Coord|35|46|48|N|139|43|12|E|display=inline}}", 35.78, 139.72, true},
+		{"{{coord|33|51|S|151|12|E}}", -33.85, 151.2, true},
+		{"{{coord|region:JP}}", 0, 0, false},
+		{"no template", 0, 0, false},
+	} {
+		lat, lon, ok := parseCoord(tt.text)
+		if ok != tt.ok || math.Abs(lat-tt.lat) > 1e-9 || math.Abs(lon-tt.lon) > 1e-9 {
+			t.Errorf("%q: got %v, %v, %v, want %v, %v, %v", tt.text, lat, lon, ok, tt.lat, tt.lon, tt.ok)
+		}
+	}
+}
+
+func TestResolveCoordinates(t *testing.T) {
+	stations := []Station{
+		{Name: "赤羽駅", NameEn: "Akabane", Article: "Akabane Station"},
+		{Name: "千葉駅", NameEn: "Chiba", Article: "Chiba Station"},
+		{Name: "番田駅", NameEn: "Banda Station", Article: "Banda Station"},
+	}
+
+	ResolveCoordinates(stations, []Page{
+		{Title: "Akabane Station", Revision: Revision{Text: "{{Infobox station|coordinates = {{coord|35|46|48|N|139|43|12|E|display=inline}}}}"}},
+		{Title: "Chiba Station", Revision: Revision{Text: "{{coord|35.6130|140.1135}}"}},
+		{Title: "Banda Station", Revision: Revision{Text: "no coordinates"}},
+	})
+
+	for i, want := range [][2]float64{{35.78, 139.72}, {35.6130, 140.1135}, {0, 0}} {
+		if s := stations[i]; math.Abs(s.Lat-want[0]) > 1e-9 || math.Abs(s.Lon-want[1]) > 1e-9 {
+			t.Errorf("%s: got %v, %v, want %v, %v", s.Article, s.Lat, s.Lon, want[0], want[1])
+		}
+	}
+}
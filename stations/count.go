@@ -0,0 +1,39 @@
+package stations
+
+import "sort"
+
+// Count is the number of stations with a value in a column.
+type Count struct {
+	Value string
+	N     int
+}
+
+// CountBy counts the stations by their values in the column named column, as
+// written in TSV, the most common first and ties by value. See CheckColumns
+// for the valid names.
+func CountBy(stations []Station, column string) ([]Count, error) {
+	cs, err := lookupColumns([]string{column})
+	if err != nil {
+		return nil, err
+	}
+
+	ns := make(map[string]int)
+	for _, s := range stations {
+		ns[formatValue(cs[0].value(s))]++
+	}
+
+	counts := make([]Count, 0, len(ns))
+	for v, n := range ns {
+		counts = append(counts, Count{v, n})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].N != counts[j].N {
+			return counts[i].N > counts[j].N
+		}
+
+		return counts[i].Value < counts[j].Value
+	})
+
+	return counts, nil
+}
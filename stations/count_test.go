@@ -0,0 +1,30 @@
+package stations
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCountBy(t *testing.T) {
+	ss := []Station{
+		{NameEn: "Akabane", Operator: "JR East"},
+		{NameEn: "Daikanyama", Operator: "Tokyu"},
+		{NameEn: "Abiko", Operator: "JR East"},
+		{NameEn: "Banda"},
+		{NameEn: "Fuchū", Operator: "Keio"},
+	}
+
+	counts, err := CountBy(ss, "operator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The most common first, and the ties by value with the empty one first.
+	if want := []Count{{"JR East", 2}, {"", 1}, {"Keio", 1}, {"Tokyu", 1}}; !slices.Equal(counts, want) {
+		t.Errorf("got %v, want %v", counts, want)
+	}
+
+	if _, err := CountBy(ss, "company"); err == nil {
+		t.Error("got no error for an unknown column")
+	}
+}
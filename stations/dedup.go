@@ -0,0 +1,263 @@
+package stations
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DedupOptions configures which stations Uniquify considers the same.
+type DedupOptions struct {
+	// FoldCase compares NameEn case insensitively.
+	FoldCase bool
+	// TrimStationSuffix compares NameEn without a trailing " Station", in any
+	// case. The name without it is kept since it sorts first.
+	TrimStationSuffix bool
+	// Fields lists the fields compared, by their column names; nil means all
+	// of them but ja_title and disambiguation. See CheckDedupFields.
+	Fields []string
+	// Disambiguation compares Disambiguation as well, so that the stations of
+	// the same names in different places are kept apart.
+	Disambiguation bool
+}
+
+// dedupFields copies each field that can be compared into a key.
+var dedupFields = map[string]func(k *Station, s Station){
+	"name":           func(k *Station, s Station) { k.Name = s.Name },
+	"name_kana":      func(k *Station, s Station) { k.NameKana = s.NameKana },
+	"name_en":        func(k *Station, s Station) { k.NameEn = s.NameEn },
+	"disambiguation": func(k *Station, s Station) { k.Disambiguation = s.Disambiguation },
+	"prefecture":     func(k *Station, s Station) { k.Prefecture = s.Prefecture },
+	"operator":       func(k *Station, s Station) { k.Operator = s.Operator },
+	"line":           func(k *Station, s Station) { k.Line = s.Line },
+	"code":           func(k *Station, s Station) { k.Code = s.Code },
+	"lat":            func(k *Station, s Station) { k.Lat = s.Lat },
+	"lon":            func(k *Station, s Station) { k.Lon = s.Lon },
+	"opened_year":    func(k *Station, s Station) { k.OpenedYear = s.OpenedYear },
+	"ja_title":       func(k *Station, s Station) { k.JaTitle = s.JaTitle },
+}
+
+// CheckDedupFields returns an error naming the valid fields if any of names
+// is not one.
+func CheckDedupFields(names []string) error {
+	for _, name := range names {
+		if _, ok := dedupFields[name]; !ok {
+			valid := make([]string, 0, len(dedupFields))
+			for f := range dedupFields {
+				valid = append(valid, f)
+			}
+
+			sort.Strings(valid)
+
+			return fmt.Errorf("unknown field %q (valid fields: %s)", name, strings.Join(valid, ", "))
+		}
+	}
+
+	return nil
+}
+
+// defaultDedupFields are the fields compared when Fields is nil, as
+// Station.key keeps them.
+var defaultDedupFields = []string{"name", "name_kana", "name_en", "prefecture", "operator", "line", "code", "lat", "lon", "opened_year"}
+
+// ComparedFields returns the names of the fields o compares, as listed in
+// Fields.
+func (o DedupOptions) ComparedFields() []string {
+	names := o.Fields
+	if names == nil {
+		names = defaultDedupFields
+	}
+	if o.Disambiguation && !slices.Contains(names, "disambiguation") {
+		names = append(slices.Clip(names), "disambiguation")
+	}
+
+	return names
+}
+
+const stationSuffix = " station"
+
+func (o DedupOptions) key(s Station) Station {
+	k := s.key()
+	if o.Fields != nil {
+		k = Station{}
+		for _, name := range o.Fields {
+			dedupFields[name](&k, s)
+		}
+	}
+	if o.TrimStationSuffix && len(k.NameEn) > len(stationSuffix) && strings.EqualFold(k.NameEn[len(k.NameEn)-len(stationSuffix):], stationSuffix) {
+		k.NameEn = k.NameEn[:len(k.NameEn)-len(stationSuffix)]
+	}
+	if o.FoldCase {
+		k.NameEn = strings.ToLower(k.NameEn)
+	}
+	if o.Disambiguation {
+		k.Disambiguation = s.Disambiguation
+	}
+
+	return k
+}
+
+// ID is like Station.ID but derived from the identity fields as o compares
+// them, so that the stations Uniquify keeps apart by those get distinct IDs.
+// The identity fields are the names, prefecture, operator, line and code, and
+// the disambiguation when set. The fields filled in after extraction, such as
+// the coordinates, the opened year and the Japanese title, are left out even
+// when compared, so that the IDs stay the same whether they are resolved.
+func (o DedupOptions) ID(s Station) int64 {
+	k := o.key(s)
+
+	fields := []string{k.Name, k.NameKana, k.NameEn, k.Prefecture, k.Operator, k.Line, k.Code}
+	if k.Disambiguation != "" {
+		fields = append(fields, "disambiguation="+k.Disambiguation)
+	}
+
+	return hashFields(fields...)
+}
+
+// Uniquify sorts stations by NameEn, NameKana and Name and removes
+// duplicates, keeping the first of each after sorting but preferring one whose
+// NameEn is not romanized.
+func Uniquify(stations []Station) []Station {
+	return DedupOptions{}.Uniquify(stations)
+}
+
+func (o DedupOptions) Uniquify(stations []Station) []Station {
+	u := NewUniquifier(o)
+	u.Add(stations...)
+
+	return u.Stations()
+}
+
+// lessStations orders by NameEn, NameKana and then Name.
+func lessStations(a, b Station) bool {
+	if a.NameEn != b.NameEn {
+		return a.NameEn < b.NameEn
+	}
+	if a.NameKana != b.NameKana {
+		return a.NameKana < b.NameKana
+	}
+
+	return a.Name < b.Name
+}
+
+// sortStations keeps the order in which stations equal in lessStations were
+// added.
+func sortStations(stations []Station) {
+	sort.SliceStable(stations, func(i, j int) bool { return lessStations(stations[i], stations[j]) })
+}
+
+// Uniquifier incrementally does what Uniquify does, keeping only distinct
+// stations in memory. It is not safe for concurrent use; the workers decoding
+// the blocks feed it through emit, which StreamPages calls from a single
+// goroutine.
+type Uniquifier struct {
+	opts     DedupOptions
+	index    map[Station]int
+	stations []Station
+	counts   []int
+	added    int
+}
+
+func NewUniquifier(opts DedupOptions) *Uniquifier {
+	return &Uniquifier{opts: opts, index: make(map[Station]int)}
+}
+
+// Add records stations, keeping for each key the one that sorts first, or
+// else the one whose NameEn is not romanized, or else the one added first.
+func (u *Uniquifier) Add(stations ...Station) {
+	for _, s := range stations {
+		k := u.opts.key(s)
+
+		u.added++
+
+		if i, ok := u.index[k]; ok {
+			u.counts[i]++
+			if kept := u.stations[i]; lessStations(s, kept) || !lessStations(kept, s) && kept.NameEnDerived && !s.NameEnDerived {
+				u.stations[i] = s
+			}
+			continue
+		}
+
+		u.index[k] = len(u.stations)
+		u.stations = append(u.stations, s)
+		u.counts = append(u.counts, 1)
+	}
+}
+
+// Unsorted is like Stations but in the order the first of each was added.
+func (u *Uniquifier) Unsorted() []Station {
+	return append([]Station(nil), u.stations...)
+}
+
+// Added returns the number of stations added, duplicates included.
+func (u *Uniquifier) Added() int {
+	return u.added
+}
+
+// Duplicate is a station kept by a Uniquifier and the number of times it was
+// added.
+type Duplicate struct {
+	Station Station
+	Count   int
+}
+
+// Duplicates returns the stations added more than once, the most duplicated
+// first.
+func (u *Uniquifier) Duplicates() []Duplicate {
+	var ds []Duplicate
+	for i, n := range u.counts {
+		if n > 1 {
+			ds = append(ds, Duplicate{u.stations[i], n})
+		}
+	}
+
+	sort.SliceStable(ds, func(i, j int) bool {
+		if ds[i].Count != ds[j].Count {
+			return ds[i].Count > ds[j].Count
+		}
+
+		return lessStations(ds[i].Station, ds[j].Station)
+	})
+
+	return ds
+}
+
+// Stations returns the distinct stations in the order Uniquify returns them.
+func (u *Uniquifier) Stations() []Station {
+	stations := append([]Station(nil), u.stations...)
+
+	sortStations(stations)
+
+	return stations
+}
+
+// Diff returns the stations in current but not in previous and those in
+// previous but not in current, compared as o.Uniquify does and ordered like
+// its result.
+func (o DedupOptions) Diff(previous, current []Station) (added, removed []Station) {
+	keys := func(ss []Station) map[Station]bool {
+		m := make(map[Station]bool, len(ss))
+		for _, s := range ss {
+			m[o.key(s)] = true
+		}
+
+		return m
+	}
+
+	pk, ck := keys(previous), keys(current)
+
+	for _, s := range o.Uniquify(current) {
+		if !pk[o.key(s)] {
+			added = append(added, s)
+		}
+	}
+
+	for _, s := range o.Uniquify(previous) {
+		if !ck[o.key(s)] {
+			removed = append(removed, s)
+		}
+	}
+
+	return added, removed
+}
@@ -0,0 +1,328 @@
+package stations
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDedupOptionsID(t *testing.T) {
+	tokyo := Station{Name: "府中駅", NameKana: "ふちゅう", NameEn: "Fuchū", Disambiguation: "Tokyo"}
+	hiroshima := tokyo
+	hiroshima.Disambiguation = "Hiroshima"
+
+	if got, want := (DedupOptions{}).ID(tokyo), tokyo.ID(); got != want {
+		t.Errorf("default ID = %d, want Station.ID %d", got, want)
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts DedupOptions
+		a, b Station
+		same bool
+	}{
+		{"default ignores disambiguation", DedupOptions{}, tokyo, hiroshima, true},
+		{"disambiguation", DedupOptions{Disambiguation: true}, tokyo, hiroshima, false},
+		{"fold case", DedupOptions{FoldCase: true}, tokyo, Station{Name: "府中駅", NameKana: "ふちゅう", NameEn: "FUCHŪ"}, true},
+		{"trim station suffix", DedupOptions{TrimStationSuffix: true}, tokyo, Station{Name: "府中駅", NameKana: "ふちゅう", NameEn: "Fuchū Station"}, true},
+		{"fields", DedupOptions{Fields: []string{"name"}}, tokyo, Station{Name: "府中駅", Line: "Keio Line"}, true},
+		{"fields differing", DedupOptions{Fields: []string{"name", "line"}}, tokyo, Station{Name: "府中駅", Line: "Keio Line"}, false},
+		{"default coordinates", DedupOptions{}, tokyo, Station{Name: "府中駅", NameKana: "ふちゅう", NameEn: "Fuchū", Lat: 35.67}, true},
+		{"compared coordinates", DedupOptions{Fields: []string{"name", "lat", "lon"}}, tokyo, Station{Name: "府中駅", Lat: 35.67, Lon: 139.48}, true},
+		{"enrichment", DedupOptions{}, tokyo, Station{Name: "府中駅", NameKana: "ふちゅう", NameEn: "Fuchū", OpenedYear: 1916, JaTitle: "府中駅 (東京都)", NameEnDerived: true}, true},
+	} {
+		if got := tt.opts.ID(tt.a) == tt.opts.ID(tt.b); got != tt.same {
+			t.Errorf("%s: same ID = %v, want %v", tt.name, got, tt.same)
+		}
+	}
+}
+
+func TestDedupOptionsIDUniquify(t *testing.T) {
+	// The stations Uniquify keeps apart get distinct IDs.
+	opts := DedupOptions{Disambiguation: true}
+	ss := opts.Uniquify([]Station{
+		{Name: "府中駅", NameEn: "Fuchū", Disambiguation: "Tokyo"},
+		{Name: "府中駅", NameEn: "Fuchū", Disambiguation: "Hiroshima"},
+		{Name: "府中駅", NameEn: "Fuchū", Disambiguation: "Tokyo"},
+	})
+
+	if len(ss) != 2 {
+		t.Fatalf("got %d stations, want 2", len(ss))
+	}
+
+	if opts.ID(ss[0]) == opts.ID(ss[1]) {
+		t.Errorf("got the same ID %d for %v and %v", opts.ID(ss[0]), ss[0], ss[1])
+	}
+}
+
+func TestUniquifier(t *testing.T) {
+	batches := [][]Station{
+		{{Name: "赤羽駅", NameKana: "あかばね", NameEn: "Akabane"}, {Name: "我孫子駅", NameKana: "あびこ", NameEn: "Abiko"}},
+		nil,
+		{{Name: "番田駅", NameKana: "ばんだ", NameEn: "Banda"}, {Name: "赤羽駅", NameKana: "あかばね", NameEn: "Akabane"}},
+		{{Name: "我孫子駅", NameKana: "あびこ", NameEn: "Abiko"}},
+	}
+
+	var all []Station
+	u := NewUniquifier(DedupOptions{})
+	for _, b := range batches {
+		u.Add(b...)
+		all = append(all, b...)
+	}
+
+	got, want := u.Stations(), Uniquify(all)
+	if len(want) != 3 || !slices.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestUniquifyNameEnDerived(t *testing.T) {
+	// A row whose name_en is romanized duplicates one with the same name_en
+	// written out, which is kept whichever comes first.
+	derived := Station{Name: "東京駅", NameKana: "とうきょう", NameEn: "Tōkyō", NameEnDerived: true}
+	written := Station{Name: "東京駅", NameKana: "とうきょう", NameEn: "Tōkyō"}
+
+	for _, ss := range [][]Station{{derived, written}, {written, derived}} {
+		got := Uniquify(ss)
+		if len(got) != 1 || got[0].NameEnDerived {
+			t.Errorf("%+v: got %+v, want the row not romanized only", ss, got)
+		}
+	}
+}
+
+func TestUniquifyFoldCase(t *testing.T) {
+	ss := []Station{
+		{Name: "千葉駅", NameKana: "ちば", NameEn: "chiba"},
+		{Name: "千葉駅", NameKana: "ちば", NameEn: "Chiba"},
+		{Name: "千葉駅", NameKana: "ちば", NameEn: "CHIBA"},
+		{Name: "千葉みなと駅", NameKana: "ちばみなと", NameEn: "Chiba-minato"},
+	}
+
+	if got := Uniquify(ss); len(got) != 4 {
+		t.Errorf("got %q, want the cases kept apart by default", namesEn(got))
+	}
+
+	// The name that sorts first is kept.
+	got := DedupOptions{FoldCase: true}.Uniquify(ss)
+	if want := []string{"CHIBA", "Chiba-minato"}; !slices.Equal(namesEn(got), want) {
+		t.Errorf("got %q, want %q", namesEn(got), want)
+	}
+}
+
+func TestUniquifyTrimStationSuffix(t *testing.T) {
+	ss := []Station{
+		{Name: "新宿駅", NameKana: "しんじゅく", NameEn: "Shinjuku Station"},
+		{Name: "新宿駅", NameKana: "しんじゅく", NameEn: "Shinjuku"},
+		{Name: "番田駅", NameKana: "ばんだ", NameEn: "Banda STATION"},
+		{Name: "番田駅", NameKana: "ばんだ", NameEn: "Banda"},
+		// A different kana or name is another station.
+		{Name: "大宮駅", NameKana: "おおみや", NameEn: "Ōmiya Station"},
+		{Name: "大宮駅", NameKana: "おおみや", NameEn: "Ōmiya (Kyoto)"},
+		{Name: "府中駅", NameKana: "ふちゅう", NameEn: "Fuchū Station"},
+		{Name: "府中本町駅", NameKana: "ふちゅうほんまち", NameEn: "Fuchū"},
+		{Name: "駅", NameKana: "えき", NameEn: "Station"},
+	}
+
+	if got := Uniquify(ss); len(got) != len(ss) {
+		t.Errorf("got %q, want the suffixes kept apart by default", namesEn(got))
+	}
+
+	got := DedupOptions{TrimStationSuffix: true}.Uniquify(ss)
+	want := []string{"Banda", "Fuchū", "Fuchū Station", "Shinjuku", "Station", "Ōmiya (Kyoto)", "Ōmiya Station"}
+	if !slices.Equal(namesEn(got), want) {
+		t.Errorf("got %q, want %q", namesEn(got), want)
+	}
+}
+
+func TestUniquifyDisambiguation(t *testing.T) {
+	ss := []Station{
+		{Name: "山下駅", NameKana: "やました", NameEn: "Yamashita", Disambiguation: "Tokyo"},
+		{Name: "山下駅", NameKana: "やました", NameEn: "Yamashita", Disambiguation: "Hyōgo"},
+	}
+
+	if got := Uniquify(ss); len(got) != 1 {
+		t.Errorf("got %+v, want the stations merged by default", got)
+	}
+
+	if got := (DedupOptions{Disambiguation: true}).Uniquify(ss); len(got) != 2 {
+		t.Errorf("got %+v, want the stations kept apart by their disambiguations", got)
+	}
+}
+
+func TestUniquifyTieBreaking(t *testing.T) {
+	want := []Station{
+		{Name: "府中駅", NameKana: "こう", NameEn: "Fuchū"},
+		{Name: "冨中駅", NameKana: "ふちゅう", NameEn: "Fuchū"},
+		{Name: "府中駅", NameKana: "ふちゅう", NameEn: "Fuchū"},
+		{Name: "府中本町駅", NameKana: "ふちゅうほんまち", NameEn: "Fuchū"},
+	}
+
+	// The stations sharing NameEn are ordered by NameKana and then Name
+	// whatever the order they come in.
+	for seed := int64(0); seed < 20; seed++ {
+		ss := slices.Clone(want)
+		rand.New(rand.NewSource(seed)).Shuffle(len(ss), func(i, j int) { ss[i], ss[j] = ss[j], ss[i] })
+
+		if got := Uniquify(ss); !slices.Equal(got, want) {
+			t.Errorf("seed %d: got %+v, want %+v", seed, got, want)
+		}
+	}
+
+	// Those equal in all three keep the order they came in.
+	tokyo := Station{Name: "府中駅", NameKana: "ふちゅう", NameEn: "Fuchū", Prefecture: "Tokyo"}
+	hiroshima := Station{Name: "府中駅", NameKana: "ふちゅう", NameEn: "Fuchū", Prefecture: "Hiroshima"}
+
+	for _, ss := range [][]Station{{tokyo, hiroshima}, {hiroshima, tokyo}} {
+		if got := Uniquify(ss); !slices.Equal(got, ss) {
+			t.Errorf("got %+v, want %+v", got, ss)
+		}
+	}
+}
+
+func TestUniquifyFields(t *testing.T) {
+	ss := []Station{
+		{Name: "赤羽駅", NameKana: "あかばね", NameEn: "Akabane", Line: "Saikyō Line"},
+		{Name: "赤羽駅", NameKana: "あかはね", NameEn: "Akabane", Line: "Keihin-Tōhoku Line"},
+		{Name: "赤羽駅", NameKana: "あかばね", NameEn: "Akabane", Line: "Keihin-Tōhoku Line"},
+		{Name: "赤羽岩淵駅", NameKana: "あかばねいわぶち", NameEn: "Akabane-iwabuchi", Line: "Namboku Line"},
+	}
+
+	for _, tt := range []struct {
+		fields []string
+		want   []Station
+	}{
+		{nil, []Station{ss[1], ss[0], ss[2], ss[3]}},
+		{[]string{"name_en"}, []Station{ss[1], ss[3]}},
+		{[]string{"name_en", "line"}, []Station{ss[1], ss[0], ss[3]}},
+	} {
+		if got := (DedupOptions{Fields: tt.fields}).Uniquify(ss); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.fields, got, tt.want)
+		}
+	}
+
+	if err := CheckDedupFields([]string{"name_en", "line"}); err != nil {
+		t.Error(err)
+	}
+
+	if err := CheckDedupFields([]string{"name_en", "kana"}); err == nil || !strings.Contains(err.Error(), `unknown field "kana" (valid fields: `) {
+		t.Errorf("got %v, want an error naming the valid fields", err)
+	}
+}
+
+func TestUniquifierDuplicates(t *testing.T) {
+	akabane := Station{Name: "赤羽駅", NameKana: "あかばね", NameEn: "Akabane"}
+	banda := Station{Name: "番田駅", NameKana: "ばんだ", NameEn: "Banda"}
+	chiba := Station{Name: "千葉駅", NameKana: "ちば", NameEn: "Chiba"}
+	daikanyama := Station{Name: "代官山駅", NameKana: "だいかんやま", NameEn: "Daikanyama"}
+
+	u := NewUniquifier(DedupOptions{})
+	u.Add(chiba, banda, akabane, chiba, banda, chiba, daikanyama, banda)
+
+	if got := u.Added(); got != 8 {
+		t.Errorf("got %d added, want 8", got)
+	}
+
+	if got := len(u.Stations()); got != 4 {
+		t.Errorf("got %d stations, want 4", got)
+	}
+
+	// The most duplicated first, ties in the order of Uniquify.
+	want := []Duplicate{{banda, 3}, {chiba, 3}}
+	if got := u.Duplicates(); !slices.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// duplicatedBlocks returns blocks of n stations each, drawn from unique
+// distinct ones, as a dump lists the same stations on several pages.
+func duplicatedBlocks(blocks, n, unique int) [][]Station {
+	bs := make([][]Station, blocks)
+	for b := range bs {
+		for i := 0; i < n; i++ {
+			id := (b*n + i*7) % unique
+			bs[b] = append(bs[b], Station{
+				Name:     fmt.Sprintf("駅%d", id),
+				NameKana: "えき",
+				NameEn:   fmt.Sprintf("Station %d", id),
+				Line:     fmt.Sprintf("Line %d", id%10),
+				Source:   fmt.Sprintf("List %d", b),
+			})
+		}
+	}
+
+	return bs
+}
+
+func TestUniquifierMatchesUniquify(t *testing.T) {
+	blocks := duplicatedBlocks(20, 50, 300)
+
+	var all []Station
+
+	u := NewUniquifier(DedupOptions{})
+	for _, b := range blocks {
+		u.Add(b...)
+		all = append(all, b...)
+	}
+
+	if got, want := u.Stations(), Uniquify(all); !slices.Equal(got, want) {
+		t.Errorf("got %d stations, want the %d of Uniquify", len(got), len(want))
+	}
+
+	if got := u.Added(); got != 20*50 {
+		t.Errorf("got %d added, want %d", got, 20*50)
+	}
+}
+
+// BenchmarkUniquify compares collecting the stations of all the blocks before
+// deduplicating them with adding each block to a Uniquifier, which keeps only
+// the distinct ones.
+func BenchmarkUniquify(b *testing.B) {
+	blocks := duplicatedBlocks(400, 500, 1000)
+
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			var all []Station
+			for _, bl := range blocks {
+				all = append(all, bl...)
+			}
+
+			Uniquify(all)
+		}
+	})
+
+	b.Run("incremental", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			u := NewUniquifier(DedupOptions{})
+			for _, bl := range blocks {
+				u.Add(bl...)
+			}
+
+			u.Stations()
+		}
+	})
+}
+
+func TestUniquifierUnsorted(t *testing.T) {
+	chiba := Station{Name: "千葉駅", NameKana: "ちば", NameEn: "Chiba"}
+	banda := Station{Name: "番田駅", NameKana: "ばんだ", NameEn: "Banda"}
+	akabane := Station{Name: "赤羽駅", NameKana: "あかばね", NameEn: "Akabane"}
+
+	u := NewUniquifier(DedupOptions{})
+	u.Add(chiba, banda, chiba)
+	u.Add(akabane, banda)
+
+	// The first seen of each, duplicates apart from each other included.
+	if got, want := u.Unsorted(), []Station{chiba, banda, akabane}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", namesEn(got), namesEn(want))
+	}
+
+	if got, want := u.Stations(), []Station{akabane, banda, chiba}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want the sorted %q", namesEn(got), namesEn(want))
+	}
+}
@@ -0,0 +1,495 @@
+package stations
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+)
+
+type Block struct {
+	Offset int64  `xml:"-"`
+	Pages  []Page `xml:"page"`
+	// Missing has the index entries of the block whose page is not in it,
+	// which means the index is not of the dump. It is only filled by
+	// StreamPages.
+	Missing []IndexEntry `xml:"-"`
+}
+
+type Page struct {
+	ID       int64    `xml:"id"`
+	Title    string   `xml:"title"`
+	Revision Revision `xml:"revision"`
+	Redirect bool     `xml:"-"`
+}
+
+type Revision struct {
+	Text string `xml:"text"`
+}
+
+// Decompressor wraps a compressed stream of the index or the dump.
+type Decompressor func(io.Reader) (io.Reader, error)
+
+// Bzip2 is the Decompressor for bzip2 streams.
+func Bzip2(r io.Reader) (io.Reader, error) {
+	return bzip2.NewReader(r), nil
+}
+
+// Gzip is the Decompressor for gzip streams.
+func Gzip(r io.Reader) (io.Reader, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return zr, nil
+}
+
+// Uncompressed is the Decompressor for plain XML and text.
+func Uncompressed(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+// Sniff is the Decompressor picking Bzip2, Gzip or Uncompressed from the
+// magic bytes at the start of r.
+func Sniff(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(3)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return Bzip2(br)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return Gzip(br)
+	default:
+		return Uncompressed(br)
+	}
+}
+
+// DecompressorFor selects the decompression from the file extension,
+// falling back to Sniff for unknown extensions and stdin.
+func DecompressorFor(fileName string) Decompressor {
+	switch filepath.Ext(fileName) {
+	case ".bz2":
+		return Bzip2
+	case ".gz":
+		return Gzip
+	case ".xml", ".txt":
+		return Uncompressed
+	default:
+		return Sniff
+	}
+}
+
+// ExtractOptions configures ExtractPages.
+type ExtractOptions struct {
+	// Decompress decompresses each block; nil means Bzip2.
+	Decompress Decompressor
+	// Jobs is the number of blocks decompressed and decoded concurrently,
+	// since each is a bzip2 stream of its own; zero or less means
+	// runtime.GOMAXPROCS(0).
+	Jobs int
+	// Skip, if not nil, is called with each block failing to decompress or
+	// decode, which is then left out instead of failing the extraction.
+	Skip func(offset int64, err error)
+	// InMemory tells that the dump is in memory already, as when it is memory
+	// mapped, so that the blocks are decompressed from it in place rather
+	// than read into a buffer first.
+	InMemory bool
+}
+
+// ExtractPages decodes the blocks of the multistream dump r referenced by
+// index and returns the indexed pages in them, ordered by block offset
+// regardless of Jobs.
+func ExtractPages(r io.ReaderAt, index *Index, opts ExtractOptions) ([]Page, error) {
+	var pages []Page
+
+	err := StreamPages(r, index, opts, func(b Block) error {
+		pages = append(pages, b.Pages...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+// StreamPages is like ExtractPages but hands each block with its indexed
+// pages to emit as soon as the preceding blocks are done, so they need not be
+// kept in memory. emit is called from a single goroutine.
+func StreamPages(r io.ReaderAt, index *Index, opts ExtractOptions, emit func(Block) error) error {
+	return StreamPagesContext(context.Background(), r, index, opts, emit)
+}
+
+// StreamPagesContext is like StreamPages but stops between blocks once ctx
+// is done, returning ctx.Err().
+func StreamPagesContext(ctx context.Context, r io.ReaderAt, index *Index, opts ExtractOptions, emit func(Block) error) error {
+	decompress := opts.Decompress
+	if decompress == nil {
+		decompress = Bzip2
+	}
+
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+
+	offsets := make([]int64, 0, len(index.OnDump))
+	for offset := range index.OnDump {
+		offsets = append(offsets, offset)
+	}
+
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	type result struct {
+		i     int
+		block Block
+		err   error
+	}
+
+	var (
+		next    = make(chan int)
+		results = make(chan result)
+		failed  int32
+		wg      sync.WaitGroup
+	)
+
+	for n := 0; n < jobs; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range next {
+				if atomic.LoadInt32(&failed) != 0 || ctx.Err() != nil {
+					continue
+				}
+
+				offset := offsets[i]
+				pages, missing, err := extractBlock(io.NewSectionReader(r, offset, index.BlockSize[offset]), index.OnDump[offset], decompress, !opts.InMemory)
+				results <- result{i, Block{Offset: offset, Pages: pages, Missing: missing}, err}
+			}
+		}()
+	}
+
+	go func() {
+		for i := range offsets {
+			next <- i
+		}
+
+		close(next)
+		wg.Wait()
+		close(results)
+	}()
+
+	var (
+		pending = make(map[int]result)
+		done    int
+		err     error
+	)
+
+	for res := range results {
+		if err != nil {
+			continue
+		}
+
+		if err = ctx.Err(); err != nil {
+			atomic.StoreInt32(&failed, 1)
+			continue
+		}
+
+		if res.err != nil && opts.Skip == nil {
+			err = res.err
+			atomic.StoreInt32(&failed, 1)
+			continue
+		}
+
+		pending[res.i] = res
+
+		for res, ok := pending[done]; ok; res, ok = pending[done] {
+			delete(pending, done)
+			done++
+
+			if res.err != nil {
+				opts.Skip(res.block.Offset, res.err)
+				continue
+			}
+
+			if err = emit(res.block); err != nil {
+				atomic.StoreInt32(&failed, 1)
+				break
+			}
+		}
+	}
+
+	if err == nil && done < len(offsets) {
+		err = ctx.Err()
+	}
+
+	return err
+}
+
+// blockBuffers holds the buffers the compressed blocks are read into, shared
+// by the jobs of StreamPagesContext so that each block does not allocate its
+// own.
+var blockBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// maxPooledBlockSize is the capacity beyond which a buffer is dropped rather
+// than pooled, so that an unusually large block does not stay in memory.
+const maxPooledBlockSize = 4 << 20
+
+// extractBlock decodes the pages in a block one by one as they are
+// decompressed, keeping only those in entries, in their order, and returns
+// the entries not found as well. If pooled, the compressed block is read at
+// once into a pooled buffer.
+func extractBlock(r io.Reader, entries []IndexEntry, decompress Decompressor, pooled bool) ([]Page, []IndexEntry, error) {
+	if pooled {
+		buf := blockBuffers.Get().(*bytes.Buffer)
+		buf.Reset()
+
+		defer func() {
+			if buf.Cap() <= maxPooledBlockSize {
+				blockBuffers.Put(buf)
+			}
+		}()
+
+		if _, err := buf.ReadFrom(r); err != nil {
+			return nil, nil, fmt.Errorf("failed to read dump file: %w", err)
+		}
+
+		// A bytes.Reader is an io.ByteReader, so the decompressor does not
+		// wrap it in a bufio.Reader of its own.
+		r = bytes.NewReader(buf.Bytes())
+	}
+
+	zr, err := decompress(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decompress dump file: %w", err)
+	}
+
+	wanted := make(map[int64]*Page, len(entries))
+	for _, e := range entries {
+		wanted[e.ID] = nil
+	}
+
+	// A block is a run of <page> elements without a root. The decompressor
+	// reads on into the following streams, and the block read up to the end
+	// of the dump is followed by the </mediawiki> of the footer, so the root
+	// is <mediawiki> and the pages end at the first end element closing it.
+	d := xml.NewDecoder(io.MultiReader(strings.NewReader("<mediawiki>"), zr, strings.NewReader("</mediawiki>")))
+
+loop:
+	for {
+		t, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, nil, fmt.Errorf("failed to decode pages: %w", err)
+		}
+
+		var se xml.StartElement
+
+		switch t := t.(type) {
+		case xml.EndElement:
+			break loop
+		case xml.StartElement:
+			se = t
+		default:
+			continue
+		}
+
+		if se.Name.Local != "page" {
+			continue
+		}
+
+		p, ok, err := decodePage(d, func(p *Page) bool { _, ok := wanted[p.ID]; return ok })
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode pages: %w", err)
+		}
+
+		if ok {
+			wanted[p.ID] = &p
+		}
+	}
+
+	var (
+		pages   []Page
+		missing []IndexEntry
+	)
+
+	for _, e := range entries {
+		if p := wanted[e.ID]; p != nil {
+			pages = append(pages, *p)
+		} else {
+			missing = append(missing, e)
+		}
+	}
+
+	return pages, missing, nil
+}
+
+// ExtractPagesSequentially scans the whole decompressed dump r without
+// seeking and returns the indexed pages in it.
+func ExtractPagesSequentially(r io.Reader, index *Index) ([]Page, error) {
+	var pages []Page
+
+	err := StreamPagesSequentially(r, index, func(b Block) error {
+		pages = append(pages, b.Pages...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+// StreamPagesSequentially is like ExtractPagesSequentially but hands each
+// indexed page to emit, as a block of its own, as soon as it is decoded.
+func StreamPagesSequentially(r io.Reader, index *Index, emit func(Block) error) error {
+	return StreamPagesSequentiallyContext(context.Background(), r, index, emit)
+}
+
+// StreamPagesSequentiallyContext is like StreamPagesSequentially but stops
+// between pages once ctx is done, returning ctx.Err().
+func StreamPagesSequentiallyContext(ctx context.Context, r io.Reader, index *Index, emit func(Block) error) error {
+	d := xml.NewDecoder(bufio.NewReader(r))
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		t, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return fmt.Errorf("failed to read dump file: %w", err)
+		}
+
+		se, ok := t.(xml.StartElement)
+		if !ok || se.Name.Local != "page" {
+			continue
+		}
+
+		p, ok, err := decodePage(d, func(p *Page) bool { _, ok := index.OnID[p.ID]; return ok })
+		if err != nil {
+			return fmt.Errorf("failed to decode page: %w", err)
+		}
+
+		if ok {
+			if err := emit(Block{Offset: index.OnID[p.ID].Offset, Pages: []Page{p}}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// StreamPagesByTitle is like StreamPagesSequentially but picks the pages by
+// their titles satisfying keep, so that no index is needed. The blocks have
+// Offset zero.
+func StreamPagesByTitle(r io.Reader, keep func(title []byte) bool, emit func(Block) error) error {
+	return StreamPagesByTitleContext(context.Background(), r, keep, emit)
+}
+
+// StreamPagesByTitleContext is like StreamPagesByTitle but stops between
+// pages once ctx is done, returning ctx.Err().
+func StreamPagesByTitleContext(ctx context.Context, r io.Reader, keep func(title []byte) bool, emit func(Block) error) error {
+	d := xml.NewDecoder(bufio.NewReader(r))
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		t, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return fmt.Errorf("failed to read dump file: %w", err)
+		}
+
+		se, ok := t.(xml.StartElement)
+		if !ok || se.Name.Local != "page" {
+			continue
+		}
+
+		p, ok, err := decodePage(d, func(p *Page) bool { return keep([]byte(p.Title)) })
+		if err != nil {
+			return fmt.Errorf("failed to decode page: %w", err)
+		}
+
+		if ok {
+			if err := emit(Block{Pages: []Page{p}}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodePage decodes the rest of the <page> element just started in d,
+// skipping it as soon as its <title> and <id>, which come first, show it is
+// not wanted so that its revision text, the bulk of it, is not decoded.
+func decodePage(d *xml.Decoder, wanted func(p *Page) bool) (Page, bool, error) {
+	var p Page
+
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return Page{}, false, err
+		}
+
+		switch t := t.(type) {
+		case xml.EndElement:
+			p.Redirect = isRedirect(p.Revision.Text)
+			return p, true, nil
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "title":
+				err = d.DecodeElement(&p.Title, &t)
+			case "id":
+				if err = d.DecodeElement(&p.ID, &t); err == nil && !wanted(&p) {
+					return Page{}, false, d.Skip()
+				}
+			case "revision":
+				err = d.DecodeElement(&p.Revision, &t)
+			default:
+				err = d.Skip()
+			}
+			if err != nil {
+				return Page{}, false, err
+			}
+		}
+	}
+}
+
+func isRedirect(text string) bool {
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+	return len(text) >= len("#REDIRECT") && strings.EqualFold(text[:len("#REDIRECT")], "#REDIRECT")
+}
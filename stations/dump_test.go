@@ -0,0 +1,411 @@
+package stations
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtractPagesRedirect(t *testing.T) {
+	dump := `<mediawiki>
+  <page><title>List of railway stations in Japan: A</title><id>1</id><revision><text>|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね）</text></revision></page>
+  <page><title>List of railway stations in Japan: E</title><id>2</id><revision><text>  #redirect [[List of railway stations in Japan: A]]
+|[[Bogus Station|Bogus]] ||[[:ja:偽駅|偽駅]]（にせ）</text></revision></page>
+</mediawiki>`
+	index := &Index{OnID: map[int64]*IndexEntry{1: {ID: 1}, 2: {ID: 2}}}
+
+	pages, err := ExtractPagesSequentially(strings.NewReader(dump), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pages) != 2 || pages[0].Redirect || !pages[1].Redirect {
+		t.Fatalf("got %+v, want A and the redirect E", pages)
+	}
+
+	if ss := ExtractStations(pages, nil); len(ss) != 1 || ss[0].NameEn != "Akabane" {
+		t.Errorf("got %+v, want the redirect skipped", ss)
+	}
+}
+
+func TestStreamPagesContextCanceled(t *testing.T) {
+	bz, err := os.ReadFile("testdata/multistream-index.txt.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ExtractIndex(bzip2.NewReader(bytes.NewReader(bz)), func([]byte) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open("testdata/multistream.xml.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	emitted := 0
+	emit := func(Block) error {
+		emitted++
+		return nil
+	}
+
+	if err := StreamPagesContext(ctx, f, index, ExtractOptions{Jobs: 2}, emit); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+
+	dump := "<mediawiki><page><title>A</title><id>1</id><revision><text>a</text></revision></page></mediawiki>"
+	if err := StreamPagesSequentiallyContext(ctx, strings.NewReader(dump), &Index{OnID: map[int64]*IndexEntry{1: {ID: 1}}}, emit); !errors.Is(err, context.Canceled) {
+		t.Errorf("sequentially: got %v, want %v", err, context.Canceled)
+	}
+
+	if emitted != 0 {
+		t.Errorf("got %d blocks emitted after canceling", emitted)
+	}
+}
+
+func TestIsRedirect(t *testing.T) {
+	for text, want := range map[string]bool{
+		"#REDIRECT [[A]]":       true,
+		"  #redirect [[A]]":     true,
+		"\n#Redirect[[A]]":      true,
+		"See #REDIRECT":         false,
+		"|[[A Station|A]] || …": false,
+		"":                      false,
+	} {
+		if got := isRedirect(text); got != want {
+			t.Errorf("isRedirect(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestDecompressors(t *testing.T) {
+	const xml = "<page><title>A</title></page>"
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(xml))
+	zw.Close()
+
+	bz, err := os.ReadFile("testdata/multistream-index.txt.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		decompress Decompressor
+		data       []byte
+		prefix     string
+	}{
+		{"uncompressed", Uncompressed, []byte(xml), xml},
+		{"sniffed uncompressed", Sniff, []byte(xml), xml},
+		{"sniffed short", Sniff, []byte("<a"), "<a"},
+		{"sniffed gzip", Sniff, gz.Bytes(), xml},
+		{"sniffed bzip2", Sniff, bz, "87:10:Other page"},
+		{"by .xml", DecompressorFor("dump.xml"), []byte(xml), xml},
+		{"by .gz", DecompressorFor("dump.xml.gz"), gz.Bytes(), xml},
+		{"by .bz2", DecompressorFor("index.txt.bz2"), bz, "87:10:Other page"},
+		{"by -", DecompressorFor("-"), gz.Bytes(), xml},
+	} {
+		zr, err := tt.decompress(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		b, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if !bytes.HasPrefix(b, []byte(tt.prefix)) {
+			t.Errorf("%s: got %q, want it to start with %q", tt.name, b, tt.prefix)
+		}
+	}
+}
+
+// gzipDump returns a gzipped multistream dump of blocks blocks of n pages
+// each and its index of all the pages.
+func gzipDump(tb testing.TB, blocks, n int) ([]byte, *Index) {
+	tb.Helper()
+
+	var dump, index bytes.Buffer
+	for b := 0; b < blocks; b++ {
+		offset := dump.Len()
+
+		zw := gzip.NewWriter(&dump)
+		for i := 0; i < n; i++ {
+			id := b*n + i
+			fmt.Fprintf(zw, "<page><title>Page %d</title><id>%d</id><revision><text>%s</text></revision></page>\n", id, id, strings.Repeat(fmt.Sprintf("|[[Station %d]] || 駅%d（えき） || Tokyo\n", id, id), 20))
+			fmt.Fprintf(&index, "%d:%d:Page %d\n", offset, id, id)
+		}
+		zw.Close()
+	}
+
+	idx, err := ExtractIndex(&index, func([]byte) bool { return true })
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return dump.Bytes(), idx
+}
+
+// bufferedBlock decodes the block at offset the simple way, decompressing it
+// whole into memory and unmarshaling every page in it, and returns the pages
+// in entries.
+func bufferedBlock(tb testing.TB, dump []byte, index *Index, offset int64, entries []IndexEntry) []Page {
+	zr, err := gzip.NewReader(bytes.NewReader(dump[offset : offset+index.BlockSize[offset]]))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var block struct {
+		Pages []Page `xml:"page"`
+	}
+	if err := xml.Unmarshal([]byte("<mediawiki>"+string(b)+"</mediawiki>"), &block); err != nil {
+		tb.Fatal(err)
+	}
+
+	var pages []Page
+	for _, e := range entries {
+		for _, p := range block.Pages {
+			if p.ID == e.ID {
+				pages = append(pages, p)
+			}
+		}
+	}
+
+	return pages
+}
+
+func TestExtractBlockStreaming(t *testing.T) {
+	dump, index := gzipDump(t, 4, 10)
+	index.SetDumpSize(int64(len(dump)))
+
+	for offset, entries := range index.OnDump {
+		pages, missing, err := extractBlock(bytes.NewReader(dump[offset:offset+index.BlockSize[offset]]), entries, Gzip, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := bufferedBlock(t, dump, index, offset, entries); !slices.Equal(pages, want) || len(missing) != 0 {
+			t.Errorf("block at %d: got %d pages and %d missing, want the %d decoded at once", offset, len(pages), len(missing), len(want))
+		}
+	}
+}
+
+// BenchmarkExtractBlock compares decoding a large block page by page as it is
+// decompressed with decompressing it whole first.
+func BenchmarkExtractBlock(b *testing.B) {
+	dump, index := gzipDump(b, 1, 100)
+	index.SetDumpSize(int64(len(dump)))
+	entries := index.OnDump[0]
+
+	b.Run("streaming", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			if _, _, err := extractBlock(bytes.NewReader(dump), entries, Gzip, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			bufferedBlock(b, dump, index, 0, entries)
+		}
+	})
+}
+
+func titles(pages []Page) []string {
+	var ts []string
+	for _, p := range pages {
+		ts = append(ts, p.Title)
+	}
+
+	return ts
+}
+
+func TestExtractBlockWanted(t *testing.T) {
+	dump, index := gzipDump(t, 1, 10)
+	index.SetDumpSize(int64(len(dump)))
+	entries := index.OnDump[0]
+
+	// The wanted pages, in the order of the index whatever their order in the
+	// block, and one that is not in the block.
+	wanted := []IndexEntry{entries[7], entries[2], entries[5], {ID: 99, Title: "Page 99"}}
+
+	pages, missing, err := extractBlock(bytes.NewReader(dump), wanted, Gzip, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := bufferedBlock(t, dump, index, 0, wanted); !slices.Equal(pages, want) {
+		t.Errorf("got %q, want %q", titles(pages), titles(want))
+	}
+
+	if len(missing) != 1 || missing[0].ID != 99 {
+		t.Errorf("got %v missing, want page 99", missing)
+	}
+}
+
+// BenchmarkExtractBlockWanted compares decoding all the pages of a block with
+// decoding a few of them, skipping the text of the others.
+func BenchmarkExtractBlockWanted(b *testing.B) {
+	dump, index := gzipDump(b, 1, 100)
+	index.SetDumpSize(int64(len(dump)))
+	entries := index.OnDump[0]
+
+	for _, n := range []int{100, 3} {
+		b.Run(fmt.Sprintf("wanted=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, _, err := extractBlock(bytes.NewReader(dump), entries[:n], Gzip, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractPagesZeroMatching(t *testing.T) {
+	// The block has none of the pages its entries point to, as with an index
+	// of another dump.
+	dump, index := gzipDump(t, 2, 3)
+
+	stale, err := ExtractIndex(strings.NewReader("0:97:Page 97\n0:98:Page 98\n"+fmt.Sprintf("%d:3:Page 3\n", index.BlockSize[0])), func([]byte) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var blocks []Block
+	err = StreamPages(bytes.NewReader(dump), stale, ExtractOptions{Decompress: Gzip}, func(b Block) error {
+		blocks = append(blocks, b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blocks) != 2 || len(blocks[0].Pages) != 0 || len(blocks[0].Missing) != 2 || len(blocks[1].Pages) != 1 || len(blocks[1].Missing) != 0 {
+		t.Errorf("got %+v, want the pages of the first block missing", blocks)
+	}
+}
+
+// readFixture returns the multistream dump in testdata and its index of the
+// pages whose title satisfies shouldIndex.
+func readFixture(t *testing.T, shouldIndex func([]byte) bool) ([]byte, *Index) {
+	t.Helper()
+
+	dump, err := os.ReadFile("testdata/multistream.xml.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open("testdata/multistream-index.txt.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	index, err := ExtractIndex(bzip2.NewReader(f), shouldIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dump, index
+}
+
+func TestExtractPagesLastBlock(t *testing.T) {
+	// The last block of the fixture is the stream before the footer, which
+	// the bzip2 reader of the block goes on to decompress.
+	dump, index := readFixture(t, func(title []byte) bool {
+		return strings.HasSuffix(string(title), ": F") || string(title) == "Chiba Station"
+	})
+
+	for _, jobs := range []int{1, 4} {
+		pages, err := ExtractPages(bytes.NewReader(dump), index, ExtractOptions{Jobs: jobs})
+		if err != nil {
+			t.Fatalf("jobs %d: %v", jobs, err)
+		}
+
+		got := strings.Join(titles(pages), ", ")
+		if want := "Chiba Station, List of railway stations in Japan: F"; got != want {
+			t.Errorf("jobs %d: got %q, want %q", jobs, got, want)
+		}
+	}
+}
+
+func TestExtractPagesLastBlockBounded(t *testing.T) {
+	dump, index := readFixture(t, func(title []byte) bool { return strings.HasSuffix(string(title), ": F") })
+	index.SetDumpSize(int64(len(dump)))
+
+	pages, err := ExtractPages(bytes.NewReader(dump), index, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pages) != 1 || pages[0].ID != 61 {
+		t.Errorf("got %v, want page 61", titles(pages))
+	}
+}
+
+func TestStreamPagesInMemory(t *testing.T) {
+	dump, index := gzipDump(t, 8, 10)
+
+	for _, inMemory := range []bool{false, true} {
+		pages, err := ExtractPages(bytes.NewReader(dump), index, ExtractOptions{Decompress: Gzip, Jobs: 3, InMemory: inMemory})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(pages) != 80 || pages[0].ID != 0 || pages[79].ID != 79 {
+			t.Errorf("in memory %v: got %d pages from %d to %d, want 80 in order", inMemory, len(pages), pages[0].ID, pages[len(pages)-1].ID)
+		}
+	}
+}
+
+// BenchmarkStreamPages measures the wall-clock time of extracting all the
+// pages of a dump, one block at a time and concurrently, with the blocks read
+// into pooled buffers and decompressed in place.
+func BenchmarkStreamPages(b *testing.B) {
+	dump, index := gzipDump(b, 64, 20)
+
+	for _, jobs := range []int{1, 4} {
+		for _, inMemory := range []bool{false, true} {
+			b.Run(fmt.Sprintf("jobs=%d/in-memory=%v", jobs, inMemory), func(b *testing.B) {
+				b.SetBytes(int64(len(dump)))
+				b.ReportAllocs()
+
+				for i := 0; i < b.N; i++ {
+					err := StreamPages(bytes.NewReader(dump), index, ExtractOptions{Decompress: Gzip, Jobs: jobs, InMemory: inMemory}, func(Block) error { return nil })
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
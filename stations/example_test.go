@@ -0,0 +1,61 @@
+package stations_test
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"log"
+	"os"
+
+	"github.com/hirofumi/railway-stations-in-japan/stations"
+)
+
+func Example() {
+	index, err := os.Open("testdata/multistream-index.txt.bz2")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer index.Close()
+
+	dump, err := os.Open("testdata/multistream.xml.bz2")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dump.Close()
+
+	// The index lists the pages of every block of the dump; only the list
+	// pages are kept.
+	idx, err := stations.ExtractIndex(bzip2.NewReader(index), func(title []byte) bool {
+		return bytes.HasPrefix(title, []byte(stations.ListPagePrefix))
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pages, err := stations.ExtractPages(dump, idx, stations.ExtractOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ss := stations.ExtractStations(pages, nil)
+	ss = stations.RemoveDisambiguations(ss)
+	ss = stations.Uniquify(ss)
+
+	output := stations.OutputOptions{Columns: []string{"name", "name_kana", "name_en"}}
+	if err := output.WriteTSV(os.Stdout, ss); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// name	name_kana	name_en
+	// 我孫子駅	あびこ	Abiko
+	// 赤羽駅	あかばね	Akabane
+	// 赤羽駅	あかばね	Akabane
+	// 赤羽駅	あかばね	Akabane
+	// 番田駅	ばんだ	Banda
+	// 番田駅	ばんだ	Banda Station
+	// 千葉駅	ちば	Chiba
+	// 千葉みなと駅	ちばminato	Chiba-minato
+	// 代官山駅	だいかんやま	Daikanyama
+	// 道後温泉駅	どうごおんせん	Dōgo Onsen Station
+	// 府中駅	ふちゅう	Fuchū
+	// 千葉駅	ちば	chiba
+}
@@ -0,0 +1,214 @@
+package stations
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Reject is called with each station a filter drops and the reason why.
+type Reject func(s Station, reason string)
+
+// FilterImplausible drops the stations whose NameEn has a newline, is longer
+// than maxLength runes (unless maxLength is zero or less) or has markup left
+// in it, since those are captures of something other than a name.
+func FilterImplausible(stations []Station, maxLength int, reject Reject) []Station {
+	ss := make([]Station, 0, len(stations))
+
+	for _, s := range stations {
+		if reason := implausibility(s.NameEn, maxLength); reason != "" {
+			if reject != nil {
+				reject(s, reason)
+			}
+			continue
+		}
+
+		ss = append(ss, s)
+	}
+
+	return ss
+}
+
+func implausibility(name string, maxLength int) string {
+	switch {
+	case strings.ContainsAny(name, "\r\n"):
+		return "English name has a newline"
+	case maxLength > 0 && utf8.RuneCountInString(name) > maxLength:
+		return fmt.Sprintf("English name is longer than %d characters", maxLength)
+	case strings.ContainsAny(name, "<>{}"):
+		return "English name has markup"
+	}
+
+	return ""
+}
+
+// FilterSparsePages drops the stations of the pages, told apart by Source,
+// that yield fewer than minStations of them, since those are unlikely to be
+// real lists.
+func FilterSparsePages(stations []Station, minStations int, reject Reject) []Station {
+	if minStations <= 0 {
+		return stations
+	}
+
+	counts := make(map[string]int)
+	for _, s := range stations {
+		counts[s.Source]++
+	}
+
+	ss := make([]Station, 0, len(stations))
+
+	for _, s := range stations {
+		if n := counts[s.Source]; n < minStations {
+			if reject != nil {
+				reject(s, fmt.Sprintf("page %q has only %d stations", s.Source, n))
+			}
+			continue
+		}
+
+		ss = append(ss, s)
+	}
+
+	return ss
+}
+
+// FilterPrefectures keeps the stations in one of prefectures, compared case
+// insensitively. An empty prefectures keeps every station.
+func FilterPrefectures(stations []Station, prefectures []string, reject Reject) []Station {
+	if len(prefectures) == 0 {
+		return stations
+	}
+
+	wanted := make(map[string]bool, len(prefectures))
+	for _, p := range prefectures {
+		wanted[strings.ToLower(strings.TrimSpace(p))] = true
+	}
+
+	ss := make([]Station, 0, len(stations))
+
+	for _, s := range stations {
+		if !wanted[strings.ToLower(strings.TrimSpace(s.Prefecture))] {
+			if reject != nil {
+				reject(s, "prefecture not selected")
+			}
+			continue
+		}
+
+		ss = append(ss, s)
+	}
+
+	return ss
+}
+
+// FilterSince keeps the stations opened in year or later. Those whose
+// OpenedYear is unknown are kept unless strict.
+func FilterSince(stations []Station, year int, strict bool, reject Reject) []Station {
+	ss := make([]Station, 0, len(stations))
+
+	for _, s := range stations {
+		reason := ""
+		switch {
+		case s.OpenedYear == 0 && strict:
+			reason = "opening year unknown"
+		case s.OpenedYear != 0 && s.OpenedYear < year:
+			reason = fmt.Sprintf("opened in %d", s.OpenedYear)
+		}
+
+		if reason != "" {
+			if reject != nil {
+				reject(s, reason)
+			}
+			continue
+		}
+
+		ss = append(ss, s)
+	}
+
+	return ss
+}
+
+// FilterExcluded drops the stations whose column named field, as written in
+// TSV, matches rx. A nil rx or an unknown field (see CheckColumns) keeps
+// every station.
+func FilterExcluded(stations []Station, rx *regexp.Regexp, field string, reject Reject) []Station {
+	cs, err := lookupColumns([]string{field})
+	if rx == nil || err != nil {
+		return stations
+	}
+
+	ss := make([]Station, 0, len(stations))
+
+	for _, s := range stations {
+		if rx.MatchString(formatValue(cs[0].value(s))) {
+			if reject != nil {
+				reject(s, fmt.Sprintf("%s matches %s", field, rx))
+			}
+			continue
+		}
+
+		ss = append(ss, s)
+	}
+
+	return ss
+}
+
+// FilterInvalidUTF8 drops the stations with a field that is not valid UTF-8,
+// which would break the readers of the output.
+func FilterInvalidUTF8(stations []Station, reject Reject) []Station {
+	ss := make([]Station, 0, len(stations))
+
+	for _, s := range stations {
+		reason := ""
+		for _, f := range s.textFields() {
+			if !utf8.ValidString(*f.value) {
+				reason = fmt.Sprintf("%s is not valid UTF-8", f.name)
+				break
+			}
+		}
+
+		if reason != "" {
+			if reject != nil {
+				reject(s, reason)
+			}
+			continue
+		}
+
+		ss = append(ss, s)
+	}
+
+	return ss
+}
+
+// IsKana reports whether s consists only of hiragana, katakana, the
+// prolonged sound mark and spaces.
+func IsKana(s string) bool {
+	for _, r := range s {
+		if !unicode.In(r, unicode.Hiragana, unicode.Katakana) && r != 'ー' && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckKana calls reject with the stations whose NameKana is not IsKana,
+// dropping them only if strict.
+func CheckKana(stations []Station, strict bool, reject Reject) []Station {
+	ss := make([]Station, 0, len(stations))
+
+	for _, s := range stations {
+		if !IsKana(s.NameKana) {
+			if reject != nil {
+				reject(s, "kana has non-kana characters")
+			}
+			if strict {
+				continue
+			}
+		}
+
+		ss = append(ss, s)
+	}
+
+	return ss
+}
@@ -0,0 +1,179 @@
+package stations
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+// rejected collects the stations a filter drops with the reasons why.
+type rejected struct {
+	names, reasons []string
+}
+
+func (r *rejected) reject(s Station, reason string) {
+	r.names = append(r.names, s.NameEn+s.Name)
+	r.reasons = append(r.reasons, reason)
+}
+
+func namesEn(ss []Station) []string {
+	var names []string
+	for _, s := range ss {
+		names = append(names, s.NameEn)
+	}
+
+	return names
+}
+
+func TestFilterImplausible(t *testing.T) {
+	var r rejected
+
+	ss := FilterImplausible([]Station{
+		{NameEn: "Akabane"},
+		{NameEn: "Akabane\n|-"},
+		{NameEn: "Ōmiya Station and the next very long caption of a table"},
+		{NameEn: "<small>Ōmiya</small>"},
+		{NameEn: "{{Ōmiya}}"},
+		{NameEn: "Dōgo Onsen"},
+	}, 32, r.reject)
+
+	if got, want := namesEn(ss), []string{"Akabane", "Dōgo Onsen"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	want := []string{"English name has a newline", "English name is longer than 32 characters", "English name has markup", "English name has markup"}
+	if !slices.Equal(r.reasons, want) {
+		t.Errorf("got reasons %q, want %q", r.reasons, want)
+	}
+
+	if ss := FilterImplausible([]Station{{NameEn: "Ōmiya Station and the next very long caption of a table"}}, 0, nil); len(ss) != 1 {
+		t.Errorf("got %v, want no length limit for 0", ss)
+	}
+}
+
+func TestFilterPrefectures(t *testing.T) {
+	ss := []Station{
+		{NameEn: "Akabane", Prefecture: "Tokyo"},
+		{NameEn: "Chiba", Prefecture: "Chiba"},
+		{NameEn: "Dōgo Onsen", Prefecture: "Ehime"},
+		{NameEn: "Banda"},
+	}
+
+	var r rejected
+	got := FilterPrefectures(ss, []string{" tokyo", "EHIME"}, r.reject)
+	if want := []string{"Akabane", "Dōgo Onsen"}; !slices.Equal(namesEn(got), want) {
+		t.Errorf("got %q, want %q", namesEn(got), want)
+	}
+
+	if want := []string{"Chiba", "Banda"}; !slices.Equal(r.names, want) {
+		t.Errorf("got rejected %q, want %q", r.names, want)
+	}
+
+	if got := FilterPrefectures(ss, nil, nil); len(got) != len(ss) {
+		t.Errorf("got %d stations, want all %d without prefectures", len(got), len(ss))
+	}
+}
+
+func TestIsKana(t *testing.T) {
+	for s, want := range map[string]bool{
+		"あかばね":     true,
+		"アカバネ":     true,
+		"とーきょー":    true,
+		"しん おおさか":  true,
+		"":         true,
+		"ちばminato": false,
+		"赤羽":       false,
+		"JR":       false,
+	} {
+		if got := IsKana(s); got != want {
+			t.Errorf("IsKana(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestCheckKana(t *testing.T) {
+	ss := []Station{{NameEn: "Chiba", NameKana: "ちば"}, {NameEn: "Chiba-minato", NameKana: "ちばminato"}}
+
+	var r rejected
+	if got := CheckKana(ss, false, r.reject); len(got) != 2 {
+		t.Errorf("got %q, want both kept without strict", namesEn(got))
+	}
+
+	if want := []string{"kana has non-kana characters"}; !slices.Equal(r.reasons, want) {
+		t.Errorf("got reasons %q, want %q", r.reasons, want)
+	}
+
+	if got, want := namesEn(CheckKana(ss, true, nil)), []string{"Chiba"}; !slices.Equal(got, want) {
+		t.Errorf("got %q with strict, want %q", got, want)
+	}
+}
+
+func TestFilterSparsePages(t *testing.T) {
+	ss := []Station{
+		{NameEn: "Abiko", Source: "A"},
+		{NameEn: "Akabane", Source: "A"},
+		{NameEn: "Bogus", Source: "Glossary"},
+		{NameEn: "Akita", Source: "A"},
+		{NameEn: "Bogus too", Source: "Glossary"},
+	}
+
+	var r rejected
+	if got, want := namesEn(FilterSparsePages(ss, 3, r.reject)), []string{"Abiko", "Akabane", "Akita"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if want := []string{`page "Glossary" has only 2 stations`, `page "Glossary" has only 2 stations`}; !slices.Equal(r.reasons, want) {
+		t.Errorf("got reasons %q, want %q", r.reasons, want)
+	}
+
+	if got := FilterSparsePages(ss, 0, nil); len(got) != len(ss) {
+		t.Errorf("got %d stations with no threshold, want all %d", len(got), len(ss))
+	}
+}
+
+func TestFilterExcluded(t *testing.T) {
+	ss := []Station{
+		{NameEn: "Akabane", Line: "Saikyō Line"},
+		{NameEn: "Haneda Airport Terminal 1", Line: "Keikyū Airport Line"},
+		{NameEn: "Tōkyō Teleport", Line: "Rinkai Line"},
+	}
+
+	for _, tt := range []struct {
+		rx    *regexp.Regexp
+		field string
+		want  []string
+	}{
+		{nil, "name_en", []string{"Akabane", "Haneda Airport Terminal 1", "Tōkyō Teleport"}},
+		{regexp.MustCompile(`Terminal|Teleport`), "name_en", []string{"Akabane"}},
+		{regexp.MustCompile(`Airport`), "line", []string{"Akabane", "Tōkyō Teleport"}},
+		{regexp.MustCompile(`.`), "bogus", []string{"Akabane", "Haneda Airport Terminal 1", "Tōkyō Teleport"}},
+	} {
+		var r rejected
+		if got := namesEn(FilterExcluded(ss, tt.rx, tt.field, r.reject)); !slices.Equal(got, tt.want) {
+			t.Errorf("%v on %s: got %q, want %q", tt.rx, tt.field, got, tt.want)
+		}
+
+		if len(r.names) != len(ss)-len(tt.want) {
+			t.Errorf("%v on %s: got %q rejected, want the others", tt.rx, tt.field, r.names)
+		}
+	}
+}
+
+func TestFilterInvalidUTF8(t *testing.T) {
+	var r rejected
+
+	ss := FilterInvalidUTF8([]Station{
+		{NameEn: "Akabane", Name: "赤羽駅"},
+		{NameEn: "Banda", Name: "番田\xff駅"},
+		{NameEn: "Chiba", Line: "Sōbu \xe3\x81 Line"},
+		{NameEn: "Dōgo Onsen"},
+	}, r.reject)
+
+	if got, want := namesEn(ss), []string{"Akabane", "Dōgo Onsen"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if want := []string{"name is not valid UTF-8", "line is not valid UTF-8"}; !slices.Equal(r.reasons, want) {
+		t.Errorf("got reasons %q, want %q", r.reasons, want)
+	}
+}
@@ -0,0 +1,191 @@
+package stations
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// ListPagePrefix is the title prefix of the list pages stations are
+// extracted from.
+const ListPagePrefix = "List of railway stations in Japan: "
+
+type Index struct {
+	BlockSize map[int64]int64
+	OnDump    map[int64][]IndexEntry
+	OnID      map[int64]*IndexEntry
+	OnTitle   map[string]*IndexEntry
+	// DuplicateTitles has the kept titles the index lists again with another
+	// ID, in the order found. Only the last entry of each is kept.
+	DuplicateTitles []DuplicateTitle
+}
+
+// DuplicateTitle is a title listed for two pages, which is a sign of a
+// broken index.
+type DuplicateTitle struct {
+	Title          string
+	PreviousID, ID int64
+}
+
+type IndexEntry struct {
+	ID     int64
+	Title  string
+	Offset int64
+}
+
+// IndexOptions configures ExtractIndexWithOptions.
+type IndexOptions struct {
+	// BufferSize is the size of the buffer reading the index; zero or less
+	// means 64 KiB.
+	BufferSize int
+	// MaxLineSize is the length of the longest line allowed; zero or less
+	// means 1 MiB.
+	MaxLineSize int
+}
+
+// ExtractIndex reads a decompressed multistream index from r, keeping the
+// entries whose title satisfies shouldIndex.
+func ExtractIndex(r io.Reader, shouldIndex func([]byte) bool) (*Index, error) {
+	return ExtractIndexWithOptions(r, shouldIndex, IndexOptions{})
+}
+
+// ExtractIndexWithOptions is like ExtractIndex but with the reading
+// configured by opts.
+func ExtractIndexWithOptions(r io.Reader, shouldIndex func([]byte) bool, opts IndexOptions) (*Index, error) {
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 64 << 10
+	}
+
+	maxLineSize := opts.MaxLineSize
+	if maxLineSize <= 0 {
+		maxLineSize = 1 << 20
+	}
+
+	index := Index{
+		OnDump:  make(map[int64][]IndexEntry),
+		OnID:    make(map[int64]*IndexEntry),
+		OnTitle: make(map[string]*IndexEntry),
+	}
+
+	// The index lists pages in dump order, so the distinct offsets come out
+	// sorted. Every block start is kept, indexed or not and offset 0 as any
+	// other, since it is where the block before it ends; no offset stands for
+	// "no block yet".
+	var offsets []int64
+
+	// The entries in order; at has the last of each title, and dropped marks
+	// the earlier ones of a title listed again.
+	var (
+		entries []IndexEntry
+		dropped []bool
+		at      = make(map[string]int)
+	)
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, min(bufferSize, maxLineSize+1)), maxLineSize+1)
+
+	for sc.Scan() {
+		line := sc.Bytes()
+
+		// A line is offset:id:title. Only the first two colons separate the
+		// fields, so a title keeps any colons of its own, as in
+		// "List of railway stations in Japan: A" or "Wikipedia:Foo: Bar".
+		records := bytes.SplitN(line, []byte(":"), 3)
+		if len(records) < 3 {
+			return nil, fmt.Errorf("failed to parse line %q: want offset:id:title", line)
+		}
+
+		offset, err := strconv.ParseInt(string(records[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse offset: %w", err)
+		}
+
+		if len(offsets) == 0 || offsets[len(offsets)-1] != offset {
+			offsets = append(offsets, offset)
+		}
+
+		if shouldIndex(records[2]) {
+			id, err := strconv.ParseInt(string(records[1]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse id: %w", err)
+			}
+
+			e := IndexEntry{
+				ID:     id,
+				Title:  string(records[2]),
+				Offset: offset,
+			}
+			if i, ok := at[e.Title]; ok {
+				if prev := entries[i]; prev.ID != e.ID {
+					index.DuplicateTitles = append(index.DuplicateTitles, DuplicateTitle{e.Title, prev.ID, e.ID})
+				}
+				dropped[i] = true
+			}
+			at[e.Title] = len(entries)
+			entries = append(entries, e)
+			dropped = append(dropped, false)
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("failed to read line: longer than %d bytes", maxLineSize)
+		}
+
+		return nil, fmt.Errorf("failed to read line: %w", err)
+	}
+
+	for i, e := range entries {
+		if !dropped[i] {
+			index.OnDump[e.Offset] = append(index.OnDump[e.Offset], e)
+		}
+	}
+
+	// The entries are pointed to once OnDump is done growing.
+	for _, es := range index.OnDump {
+		for i := range es {
+			index.OnID[es[i].ID] = &es[i]
+			index.OnTitle[es[i].Title] = &es[i]
+		}
+	}
+
+	index.BlockSize = blockSizes(offsets, func(offset int64) bool { return len(index.OnDump[offset]) > 0 })
+
+	return &index, nil
+}
+
+// blockSizes returns the sizes of the indexed blocks given the sorted
+// offsets of all the blocks: each indexed block extends to the next offset,
+// and the last one to the end of the file, denoted by math.MaxInt64.
+func blockSizes(offsets []int64, indexed func(offset int64) bool) map[int64]int64 {
+	sizes := make(map[int64]int64)
+
+	for i, offset := range offsets {
+		if !indexed(offset) {
+			continue
+		}
+
+		if i+1 < len(offsets) {
+			sizes[offset] = offsets[i+1] - offset
+		} else {
+			sizes[offset] = math.MaxInt64
+		}
+	}
+
+	return sizes
+}
+
+// SetDumpSize bounds the block sizes by the size of the dump file, so that
+// the final block, left open-ended by ExtractIndex, gets its true length.
+func (index *Index) SetDumpSize(size int64) {
+	for offset, n := range index.BlockSize {
+		if n > size-offset {
+			index.BlockSize[offset] = max(size-offset, 0)
+		}
+	}
+}
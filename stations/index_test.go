@@ -0,0 +1,184 @@
+package stations
+
+import (
+	"maps"
+	"math"
+	"strings"
+	"testing"
+)
+
+func extractIndex(t *testing.T, lines string) *Index {
+	t.Helper()
+
+	index, err := ExtractIndex(strings.NewReader(lines), func([]byte) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return index
+}
+
+func TestBlockSizes(t *testing.T) {
+	all := func(int64) bool { return true }
+
+	for _, tt := range []struct {
+		name    string
+		offsets []int64
+		indexed func(int64) bool
+		want    map[int64]int64
+	}{
+		{"single block", []int64{0}, all, map[int64]int64{0: math.MaxInt64}},
+		{"multiple blocks", []int64{0, 100, 250}, all, map[int64]int64{0: 100, 100: 150, 250: math.MaxInt64}},
+		{"final block", []int64{10, 20}, func(o int64) bool { return o == 20 }, map[int64]int64{20: math.MaxInt64}},
+		{"sparse", []int64{0, 100, 250, 400}, func(o int64) bool { return o == 100 }, map[int64]int64{100: 150}},
+		{"none indexed", []int64{0, 100}, func(int64) bool { return false }, map[int64]int64{}},
+	} {
+		if got := blockSizes(tt.offsets, tt.indexed); !maps.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractIndexBlockSizes(t *testing.T) {
+	index := extractIndex(t, "0:1:A\n0:2:B\n100:3:C\n250:4:D\n")
+
+	if want := map[int64]int64{0: 100, 100: 150, 250: math.MaxInt64}; !maps.Equal(index.BlockSize, want) {
+		t.Errorf("got %v, want %v", index.BlockSize, want)
+	}
+}
+
+func TestSetDumpSize(t *testing.T) {
+	index := extractIndex(t, "0:1:A\n100:2:B\n")
+	index.SetDumpSize(180)
+
+	if want := map[int64]int64{0: 100, 100: 80}; !maps.Equal(index.BlockSize, want) {
+		t.Errorf("got %v, want %v", index.BlockSize, want)
+	}
+}
+
+func TestExtractIndexColonTitles(t *testing.T) {
+	for _, tt := range []struct {
+		line  string
+		id    int64
+		title string
+	}{
+		{"0:1:Tokyo Station", 1, "Tokyo Station"},
+		{"0:2:List of railway stations in Japan: A", 2, "List of railway stations in Japan: A"},
+		{"0:3:Wikipedia:Foo: Bar", 3, "Wikipedia:Foo: Bar"},
+		{"0:4:Ratio 1:2:3", 4, "Ratio 1:2:3"},
+		{"0:5::", 5, ":"},
+		{"0:6:", 6, ""},
+	} {
+		index := extractIndex(t, tt.line+"\n")
+
+		if e := index.OnID[tt.id]; e == nil || e.Title != tt.title {
+			t.Errorf("%q: got %+v, want title %q", tt.line, e, tt.title)
+		}
+	}
+}
+
+func TestExtractIndexMalformedLines(t *testing.T) {
+	for _, line := range []string{"0:1", "no colons", "x:1:A", "0:x:A"} {
+		if _, err := ExtractIndex(strings.NewReader(line+"\n"), func([]byte) bool { return true }); err == nil {
+			t.Errorf("%q: got no error", line)
+		}
+	}
+}
+
+func TestExtractIndexOffsetZero(t *testing.T) {
+	lines := "0:1:A\n0:2:B\n50:3:C\n90:4:D\n"
+
+	// The block at 0 is where the first block ends, whether it is indexed or
+	// not.
+	for _, tt := range []struct {
+		name      string
+		keep      string
+		onDump    map[int64]int
+		blockSize map[int64]int64
+	}{
+		{"indexed", "A", map[int64]int{0: 1}, map[int64]int64{0: 50}},
+		{"not indexed", "C", map[int64]int{50: 1}, map[int64]int64{50: 40}},
+		{"both", "AD", map[int64]int{0: 1, 90: 1}, map[int64]int64{0: 50, 90: math.MaxInt64}},
+	} {
+		index, err := ExtractIndex(strings.NewReader(lines), func(title []byte) bool { return strings.Contains(tt.keep, string(title)) })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		onDump := make(map[int64]int)
+		for offset, es := range index.OnDump {
+			onDump[offset] = len(es)
+		}
+
+		if !maps.Equal(onDump, tt.onDump) {
+			t.Errorf("%s: got entries %v, want %v", tt.name, onDump, tt.onDump)
+		}
+
+		if !maps.Equal(index.BlockSize, tt.blockSize) {
+			t.Errorf("%s: got block sizes %v, want %v", tt.name, index.BlockSize, tt.blockSize)
+		}
+	}
+}
+
+func TestExtractIndexLongLine(t *testing.T) {
+	// Longer than the 64 KiB token bufio.Scanner allows by default.
+	title := strings.Repeat("A", 200<<10)
+	lines := "0:1:B\n10:2:" + title + "\n20:3:C\n"
+	all := func([]byte) bool { return true }
+
+	for _, opts := range []IndexOptions{{}, {BufferSize: 16}} {
+		index, err := ExtractIndexWithOptions(strings.NewReader(lines), all, opts)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+
+		if e := index.OnID[2]; e == nil || e.Title != title {
+			t.Errorf("%+v: got no entry with the long title", opts)
+		}
+
+		if e := index.OnID[3]; e == nil || e.Title != "C" {
+			t.Errorf("%+v: got %+v, want the line after the long one", opts, e)
+		}
+	}
+
+	_, err := ExtractIndexWithOptions(strings.NewReader(lines), all, IndexOptions{MaxLineSize: 100 << 10})
+	if err == nil || !strings.Contains(err.Error(), "longer than 102400 bytes") {
+		t.Errorf("got %v, want an error naming the limit", err)
+	}
+}
+
+func TestExtractIndexDuplicateTitles(t *testing.T) {
+	index := extractIndex(t, "0:1:A\n0:2:B\n10:3:A\n20:4:C\n")
+
+	if want := []DuplicateTitle{{"A", 1, 3}}; len(index.DuplicateTitles) != 1 || index.DuplicateTitles[0] != want[0] {
+		t.Errorf("got %v, want %v", index.DuplicateTitles, want)
+	}
+
+	if e := index.OnTitle["A"]; e == nil || e.ID != 3 {
+		t.Errorf("got %+v for A, want entry 3", e)
+	}
+
+	if e, ok := index.OnID[1]; ok {
+		t.Errorf("got %+v for the earlier entry, want it dropped", e)
+	}
+
+	if got := index.OnDump[0]; len(got) != 1 || got[0].ID != 2 {
+		t.Errorf("got %+v at 0, want only entry 2", got)
+	}
+
+	if index.OnID[3] != &index.OnDump[10][0] {
+		t.Error("OnID does not point into OnDump")
+	}
+}
+
+func TestExtractIndexDuplicateTitleDropsBlock(t *testing.T) {
+	index := extractIndex(t, "0:1:A\n10:2:A\n")
+
+	if _, ok := index.OnDump[0]; ok {
+		t.Errorf("got %+v at 0, want the block left out", index.OnDump[0])
+	}
+
+	if _, ok := index.BlockSize[0]; ok {
+		t.Error("got a size for the block left out")
+	}
+}
@@ -0,0 +1,201 @@
+package stations
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+var templateRegexp = regexp.MustCompile(`(?i){{\s*(nihongo|lang(?:-[a-z]+)?|illm)\s*\|([^{}]*)}}`)
+
+// UnwrapTemplates replaces the {{nihongo}}, {{lang}} and {{illm}} templates
+// left in the names with their readable text.
+func UnwrapTemplates(stations []Station) []Station {
+	ss := make([]Station, len(stations))
+
+	for i, s := range stations {
+		s.Name = unwrapTemplates(s.Name)
+		s.NameKana = unwrapTemplates(s.NameKana)
+		s.NameEn = unwrapTemplates(s.NameEn)
+		ss[i] = s
+	}
+
+	return ss
+}
+
+func unwrapTemplates(s string) string {
+	for {
+		t := templateRegexp.ReplaceAllStringFunc(s, func(m string) string {
+			sm := templateRegexp.FindStringSubmatch(m)
+			return templateText(strings.ToLower(sm[1]), strings.Split(sm[2], "|"))
+		})
+		if t == s {
+			return s
+		}
+
+		s = t
+	}
+}
+
+// templateText picks the readable text out of the template arguments:
+// {{nihongo|text|...}}, {{lang|code|text}}, {{lang-xx|text}} and
+// {{illm|title|code|foreign title|lt=text}}.
+func templateText(name string, args []string) string {
+	var positional []string
+
+	named := make(map[string]string)
+
+	for _, a := range args {
+		if k, v, ok := strings.Cut(a, "="); ok {
+			named[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		} else {
+			positional = append(positional, strings.TrimSpace(a))
+		}
+	}
+
+	i := 0
+	switch name {
+	case "lang":
+		i = 1
+	case "illm":
+		if lt, ok := named["lt"]; ok {
+			return lt
+		}
+	}
+
+	if i < len(positional) {
+		return positional[i]
+	}
+
+	return ""
+}
+
+// wikiEntities rewrites what html.UnescapeString leaves behind, or turns
+// into something unwanted in a name.
+var wikiEntities = strings.NewReplacer(
+	"{{!}}", "|",
+	"{{=}}", "=",
+	"{{snd}}", " – ",
+	"\u00a0", " ",
+)
+
+// UnescapeEntities decodes the HTML entities in the names, such as &amp; and
+// &#x2013;, which the XML decoder leaves in the wikitext, along with the
+// magic words standing for characters.
+func UnescapeEntities(stations []Station) []Station {
+	ss := make([]Station, len(stations))
+
+	for i, s := range stations {
+		s.Name = wikiEntities.Replace(html.UnescapeString(s.Name))
+		s.NameKana = wikiEntities.Replace(html.UnescapeString(s.NameKana))
+		s.NameEn = wikiEntities.Replace(html.UnescapeString(s.NameEn))
+		ss[i] = s
+	}
+
+	return ss
+}
+
+// FoldWidth folds full-width Latin letters and digits in NameEn to their
+// half-width forms. Kana is left untouched since half-width katakana means
+// something else.
+func FoldWidth(stations []Station) []Station {
+	ss := make([]Station, len(stations))
+
+	for i, s := range stations {
+		s.NameEn = width.Fold.String(s.NameEn)
+		ss[i] = s
+	}
+
+	return ss
+}
+
+// NormalizeKana widens the half-width katakana in NameKana, combining the
+// half-width sound marks with the kana before them (ｶﾞ to ガ).
+func NormalizeKana(stations []Station) []Station {
+	ss := make([]Station, len(stations))
+
+	for i, s := range stations {
+		s.NameKana = normalizeKana(s.NameKana)
+		ss[i] = s
+	}
+
+	return ss
+}
+
+var (
+	// width.Widen turns the half-width sound marks into the spacing ones,
+	// which NFC does not combine.
+	combiningMarks = strings.NewReplacer("゛", "\u3099", "゜", "\u309a")
+	spacingMarks   = strings.NewReplacer("\u3099", "゛", "\u309a", "゜")
+)
+
+func normalizeKana(kana string) string {
+	if !strings.ContainsFunc(kana, isHalfWidthKatakana) {
+		return kana
+	}
+
+	var b strings.Builder
+	for _, r := range kana {
+		if isHalfWidthKatakana(r) {
+			b.WriteString(combiningMarks.Replace(width.Widen.String(string(r))))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	// A mark left uncombined goes back to its spacing form.
+	return spacingMarks.Replace(norm.NFC.String(b.String()))
+}
+
+func isHalfWidthKatakana(r rune) bool {
+	return '\uff61' <= r && r <= '\uff9f'
+}
+
+// NormalizeWhitespace collapses the runs of whitespace in the text fields,
+// tabs and no-break spaces included, to a single space and trims them, since
+// a tab would split a TSV column and the others defeat deduplication.
+func NormalizeWhitespace(stations []Station) []Station {
+	ss := make([]Station, len(stations))
+
+	for i, s := range stations {
+		for _, f := range []*string{&s.Name, &s.NameKana, &s.NameEn, &s.Prefecture, &s.Operator, &s.Line, &s.Code} {
+			*f = strings.Join(strings.Fields(*f), " ")
+		}
+		ss[i] = s
+	}
+
+	return ss
+}
+
+// ReplaceInvalidUTF8 replaces the invalid UTF-8 sequences in the string
+// fields with U+FFFD.
+func ReplaceInvalidUTF8(stations []Station) []Station {
+	ss := make([]Station, len(stations))
+
+	for i, s := range stations {
+		for _, f := range s.textFields() {
+			*f.value = strings.ToValidUTF8(*f.value, "\uFFFD")
+		}
+		ss[i] = s
+	}
+
+	return ss
+}
+
+// ComposeNFC normalizes the names to NFC so that a kana with a combining
+// (han)dakuten equals its precomposed form.
+func ComposeNFC(stations []Station) []Station {
+	ss := make([]Station, len(stations))
+
+	for i, s := range stations {
+		s.Name = norm.NFC.String(s.Name)
+		s.NameKana = norm.NFC.String(s.NameKana)
+		s.NameEn = norm.NFC.String(s.NameEn)
+		ss[i] = s
+	}
+
+	return ss
+}
@@ -0,0 +1,140 @@
+package stations
+
+import "testing"
+
+// normalizeEn returns NameEn of a station named en after f.
+func normalizeEn(f func([]Station) []Station, en string) string {
+	return f([]Station{{NameEn: en}})[0].NameEn
+}
+
+func TestUnwrapTemplates(t *testing.T) {
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"{{nihongo|Tōkyō|東京|Tōkyō}}", "Tōkyō"},
+		{"{{lang|ja|東京}}", "東京"},
+		{"{{lang-ja|東京}}", "東京"},
+		{"{{Lang-ja|東京}} Station", "東京 Station"},
+		{"{{illm|Kami-Itabashi Station|ja|上板橋駅|lt=Kami-Itabashi}}", "Kami-Itabashi"},
+		{"{{illm|Kami-Itabashi Station|ja|上板橋駅}}", "Kami-Itabashi Station"},
+		{"{{nihongo|{{lang|ja|東京}}|東京}}", "東京"},
+		{"{{other|x}}", "{{other|x}}"},
+	} {
+		if got := normalizeEn(UnwrapTemplates, tt.name); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFoldWidth(t *testing.T) {
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"Ｓｈｉｎｊｕｋｕ", "Shinjuku"},
+		{"Ｎｏ．１ Station", "No.1 Station"},
+		{"Shinjuku", "Shinjuku"},
+	} {
+		if got := normalizeEn(FoldWidth, tt.name); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Half-width katakana in the kana is left to NormalizeKana.
+	if got := FoldWidth([]Station{{NameKana: "ｼﾝｼﾞｭｸ"}})[0].NameKana; got != "ｼﾝｼﾞｭｸ" {
+		t.Errorf("got kana %q, want it untouched", got)
+	}
+}
+
+func TestComposeNFC(t *testing.T) {
+	// か with a combining dakuten, and ハ with a combining handakuten.
+	ss := ComposeNFC([]Station{{Name: "が", NameKana: "パン", NameEn: "Café"}})
+
+	if got := ss[0]; got.Name != "が" || got.NameKana != "パン" || got.NameEn != "Café" {
+		t.Errorf("got %+v, want the precomposed forms", got)
+	}
+
+	if u := Uniquify(ComposeNFC([]Station{{NameKana: "が"}, {NameKana: "が"}})); len(u) != 1 {
+		t.Errorf("got %d stations, want the two forms deduplicated", len(u))
+	}
+}
+
+func TestUnescapeEntities(t *testing.T) {
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"Hankyu Kōbe &amp; Takarazuka", "Hankyu Kōbe & Takarazuka"},
+		{"Kōbe&#x2013;Sannomiya", "Kōbe–Sannomiya"},
+		{"Kōbe&#8211;Sannomiya", "Kōbe–Sannomiya"},
+		{"Kōbe&ndash;Sannomiya", "Kōbe–Sannomiya"},
+		{"Ōmiya&nbsp;Station", "Ōmiya Station"},
+		{"A{{!}}B{{=}}C", "A|B=C"},
+		{"Kōbe{{snd}}Sannomiya", "Kōbe – Sannomiya"},
+		{"Tōkyō", "Tōkyō"},
+	} {
+		if got := normalizeEn(UnescapeEntities, tt.name); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	ss := UnescapeEntities([]Station{{Name: "阪急&amp;阪神", NameKana: "はんきゅう&#x30fb;はんしん"}})
+	if got := ss[0]; got.Name != "阪急&阪神" || got.NameKana != "はんきゅう・はんしん" {
+		t.Errorf("got %+v, want the name and the kana unescaped too", got)
+	}
+}
+
+func TestNormalizeKana(t *testing.T) {
+	for _, tt := range []struct {
+		kana, want string
+	}{
+		{"ﾄｳｷｮｳ", "トウキョウ"},
+		{"ｶﾞｲｴﾝﾏｴ", "ガイエンマエ"},
+		{"ｼﾝｼﾞｭｸ", "シンジュク"},
+		{"ﾊﾟﾙｺ", "パルコ"},
+		{"ｳﾞｨﾗ", "ヴィラ"},
+		{"ﾄｰｷｮｰ", "トーキョー"},
+		// A sound mark on a kana that takes none is widened on its own.
+		{"ｱﾞ", "ア゛"},
+		{"ﾞ", "゛"},
+		{"とうきょう", "とうきょう"},
+		{"東京 Tokyo", "東京 Tokyo"},
+	} {
+		got := NormalizeKana([]Station{{Name: "東京駅", NameKana: tt.kana, NameEn: "ﾄｳｷｮｳ"}})[0]
+		if got.NameKana != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.kana, got.NameKana, tt.want)
+		}
+
+		if got.Name != "東京駅" || got.NameEn != "ﾄｳｷｮｳ" {
+			t.Errorf("%q: got %+v, want the other fields untouched", tt.kana, got)
+		}
+	}
+}
+
+func TestNormalizeWhitespace(t *testing.T) {
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"Ōmiya\u00a0Kōen", "Ōmiya Kōen"},
+		{"Ōmiya\tKōen", "Ōmiya Kōen"},
+		{"Ōmiya \u00a0\t Kōen", "Ōmiya Kōen"},
+		{"\u00a0 Ōmiya\t", "Ōmiya"},
+		{"Ōmiya\u3000Kōen", "Ōmiya Kōen"},
+		{"Ōmiya\u00a0\u00a0Kōen\u00a0", "Ōmiya Kōen"},
+		{"\t", ""},
+	} {
+		if got := normalizeEn(NormalizeWhitespace, tt.name); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	got := NormalizeWhitespace([]Station{{Name: "大宮公園\t駅", NameKana: "おおみや\u00a0こうえん", Line: "Tōbu\tUrban Park Line", Source: "List\tA"}})[0]
+	if want := (Station{Name: "大宮公園 駅", NameKana: "おおみや こうえん", Line: "Tōbu Urban Park Line", Source: "List\tA"}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestReplaceInvalidUTF8(t *testing.T) {
+	got := ReplaceInvalidUTF8([]Station{{Name: "番田\xff駅", NameKana: "ばんだ", NameEn: "Banda", Line: "Sōbu \xe3\x81 Line"}})[0]
+	if want := (Station{Name: "番田\uFFFD駅", NameKana: "ばんだ", NameEn: "Banda", Line: "Sōbu \uFFFD Line"}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
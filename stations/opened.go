@@ -0,0 +1,50 @@
+package stations
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// ResolveOpenedYears fills OpenedYear of each station from the opened field
+// of the infobox in its own article, looked up by title among pages.
+func ResolveOpenedYears(stations []Station, pages []Page) {
+	years := make(map[string]int, len(pages))
+	for _, p := range pages {
+		if year, ok := parseOpenedYear(p.Revision.Text); ok {
+			years[p.Title] = year
+		}
+	}
+
+	for i, s := range stations {
+		if year, ok := years[s.Article]; ok {
+			stations[i].OpenedYear = year
+		}
+	}
+}
+
+var (
+	openedRegexp = regexp.MustCompile(`(?im)^\s*\|\s*opened\s*=(.*)$`)
+	yearRegexp   = regexp.MustCompile(`\b(1[89]\d\d|20\d\d)\b`)
+)
+
+// parseOpenedYear reads the first year in the opened field of the infobox in
+// text, which may be plain text ("1 March 1885") or a template
+// ({{Start date|1885|3|1}}).
+func parseOpenedYear(text string) (int, bool) {
+	m := openedRegexp.FindStringSubmatch(text)
+	if m == nil {
+		return 0, false
+	}
+
+	y := yearRegexp.FindString(m[1])
+	if y == "" {
+		return 0, false
+	}
+
+	year, err := strconv.Atoi(y)
+	if err != nil {
+		return 0, false
+	}
+
+	return year, true
+}
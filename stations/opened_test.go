@@ -0,0 +1,23 @@
+package stations
+
+import "testing"
+
+func TestParseOpenedYear(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		year int
+		ok   bool
+	}{
+		{"{{Infobox station\n| opened = 1 March 1885\n}}", 1885, true},
+		{"{{Infobox station\n|Opened={{Start date|1894|7|20}}\n}}", 1894, true},
+		{"| opened = {{Start date|2020|3|14}} (platforms 1–2)", 2020, true},
+		{"| opened = unknown", 0, false},
+		{"| opened = 1700", 0, false},
+		{"opened in 1885", 0, false},
+		{"no infobox", 0, false},
+	} {
+		if year, ok := parseOpenedYear(tt.text); year != tt.year || ok != tt.ok {
+			t.Errorf("%q: got %d, %v, want %d, %v", tt.text, year, ok, tt.year, tt.ok)
+		}
+	}
+}
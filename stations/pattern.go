@@ -0,0 +1,76 @@
+package stations
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Pattern is a regular expression matching a station row. The English name,
+// the Japanese name and the kana are taken from the groups named en, ja and
+// kana, or else from the first three groups in that order. The optional
+// groups article (the English article title), ja_title (the Japanese article
+// title), code (the station number) and cells (the rest of the row) are only
+// used when named.
+type Pattern struct {
+	rx                                          *regexp.Regexp
+	en, ja, kana, article, jaTitle, code, cells int
+}
+
+// DefaultPatterns match the table layouts found in the list pages in the
+// English Wikipedia, in the order they are tried.
+var DefaultPatterns = []*Pattern{
+	// |[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || ...
+	MustCompilePattern(`\|\[\[(?:(?P<article>[^|]+)\|)?(?P<en>[^]]+)]]\s*\|\|\[\[:ja:(?P<ja_title>[^|]+)\|(?P<ja>[^]]+)]][(（](?P<kana>[^）)]+)[）)](?:[ \t]*\|\|(?P<cells>[^\n]*))?`),
+	// One cell per line.
+	MustCompilePattern(`\|\s*\[\[(?:(?P<article>[^|\]]+)\|)?(?P<en>[^]]+)]][ \t]*\n\|\s*\[\[:ja:(?P<ja_title>[^|]+)\|(?P<ja>[^]]+)]]\s*[(（](?P<kana>[^）)]+)[）)][ \t]*(?:\n\|(?P<cells>[^-}\n][^\n]*(?:\n\|[^-}\n][^\n]*)*))?`),
+	// |[[Akabane Station|Akabane]] || 赤羽駅（あかばね） || ...
+	MustCompilePattern(`\|\[\[(?:(?P<article>[^|]+)\|)?(?P<en>[^]]+)]]\s*\|\|\s*(?P<ja>[^\[|(（\n]+?)\s*[(（](?P<kana>[^）)]+)[）)](?:[ \t]*\|\|(?P<cells>[^\n]*))?`),
+}
+
+func CompilePattern(expr string) (*Pattern, error) {
+	rx, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if rx.NumSubexp() < 3 {
+		return nil, fmt.Errorf("pattern has %d capture groups but needs English name, Japanese name and kana in that order (or groups named en, ja and kana)", rx.NumSubexp())
+	}
+
+	p := Pattern{rx: rx, en: 1, ja: 2, kana: 3}
+
+	if rx.SubexpIndex("en") > 0 || rx.SubexpIndex("ja") > 0 || rx.SubexpIndex("kana") > 0 {
+		p.en, p.ja, p.kana = rx.SubexpIndex("en"), rx.SubexpIndex("ja"), rx.SubexpIndex("kana")
+		if p.en < 0 || p.ja < 0 || p.kana < 0 {
+			return nil, fmt.Errorf("pattern must name all of the groups en, ja and kana or none of them")
+		}
+	}
+
+	p.article = rx.SubexpIndex("article")
+	p.jaTitle = rx.SubexpIndex("ja_title")
+	p.code = rx.SubexpIndex("code")
+	p.cells = rx.SubexpIndex("cells")
+
+	return &p, nil
+}
+
+func MustCompilePattern(expr string) *Pattern {
+	p, err := CompilePattern(expr)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+func (p *Pattern) String() string {
+	return p.rx.String()
+}
+
+func submatch(m []string, i int) string {
+	if i <= 0 {
+		return ""
+	}
+
+	return m[i]
+}
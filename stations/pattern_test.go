@@ -0,0 +1,82 @@
+package stations
+
+import "testing"
+
+func TestCompilePattern(t *testing.T) {
+	for _, tt := range []struct {
+		expr, text string
+		want       Station
+	}{
+		{`\* (\w+) / (\S+) / (\S+)`, "* Akabane / 赤羽駅 / あかばね", Station{NameEn: "Akabane", Name: "赤羽駅", NameKana: "あかばね"}},
+		{`\* (?P<kana>\S+) (?P<ja>\S+) (?P<en>\w+)`, "* あかばね 赤羽駅 Akabane", Station{NameEn: "Akabane", Name: "赤羽駅", NameKana: "あかばね"}},
+	} {
+		p, err := CompilePattern(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+
+		ss := ExtractStations(testPages(tt.text), []*Pattern{p})
+		if len(ss) != 1 {
+			t.Fatalf("%s: got %d stations, want 1", tt.expr, len(ss))
+		}
+
+		got := ss[0]
+		got.Article, got.Source, got.Raw = "", "", ""
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCompilePatternErrors(t *testing.T) {
+	for _, expr := range []string{
+		`(`,
+		`(\w+) (\w+)`,
+		`(?P<en>\w+) (?P<ja>\w+) (\w+)`,
+	} {
+		if _, err := CompilePattern(expr); err == nil {
+			t.Errorf("%s: got no error", expr)
+		}
+	}
+}
+
+func TestExtractStationsPatternOrder(t *testing.T) {
+	first := MustCompilePattern(`\* (?P<en>\w+) / (?P<ja>\S+) / (?P<kana>\S+)`)
+	second := MustCompilePattern(`\* (?P<en>\w+) / (?P<ja>\S+) / (?P<kana>\S+) / (?P<cells>\w+)`)
+
+	text := "* Akabane / 赤羽駅 / あかばね / Tokyo\n|-\n* Gotanda / 五反田駅 / ごたんだ\n"
+
+	// The first pattern matching a row is used, and each row is tried from
+	// the first pattern again.
+	for _, tt := range []struct {
+		patterns    []*Pattern
+		prefectures []string
+	}{
+		{[]*Pattern{first, second}, []string{"", ""}},
+		{[]*Pattern{second, first}, []string{"Tokyo", ""}},
+	} {
+		ss := ExtractStations(testPages(text), tt.patterns)
+		if len(ss) != 2 {
+			t.Fatalf("got %d stations, want 2", len(ss))
+		}
+
+		for i, s := range ss {
+			if s.Prefecture != tt.prefectures[i] {
+				t.Errorf("%v: got prefecture %q for %s, want %q", tt.patterns, s.Prefecture, s.NameEn, tt.prefectures[i])
+			}
+		}
+	}
+}
+
+func TestDefaultPatterns(t *testing.T) {
+	for _, text := range []string{
+		"|-\n|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || [[Tokyo]]\n|-",
+		"|-\n|[[Akabane Station|Akabane]]\n|[[:ja:赤羽駅|赤羽駅]]（あかばね）\n|Tokyo\n|-",
+		"|[[Akabane Station|Akabane]] || 赤羽駅（あかばね） || Tokyo",
+	} {
+		ss := ExtractStations(testPages(text), nil)
+		if len(ss) != 1 || ss[0].Name != "赤羽駅" || ss[0].NameKana != "あかばね" || ss[0].NameEn != "Akabane" || ss[0].Prefecture != "Tokyo" {
+			t.Errorf("%q: got %+v, want Akabane in Tokyo", text, ss)
+		}
+	}
+}
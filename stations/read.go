@@ -0,0 +1,107 @@
+package stations
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// requiredColumns are the columns ReadTSV cannot do without.
+var requiredColumns = []string{"name_en"}
+
+// ReadTSV reads stations written by WriteTSV, mapping the columns to the
+// fields by the header, so they may come in any order, and ignoring the
+// columns it does not know. A number failing to parse reads as unknown.
+func ReadTSV(r io.Reader) ([]Station, error) {
+	ss, _, err := ReadTSVColumns(r)
+	return ss, err
+}
+
+// ReadTSVColumns is like ReadTSV but also returns the header.
+func ReadTSVColumns(r io.Reader) ([]Station, []string, error) {
+	br := bufio.NewReader(r)
+
+	// The comment line written with OutputOptions.Schema.
+	if first, err := br.Peek(1); err == nil && first[0] == '#' {
+		if _, err := br.ReadString('\n'); err != nil && !errors.Is(err, io.EOF) {
+			return nil, nil, fmt.Errorf("failed to read schema version: %w", err)
+		}
+	}
+
+	rd := csv.NewReader(br)
+	rd.Comma = '\t'
+	rd.LazyQuotes = true
+	rd.FieldsPerRecord = -1
+
+	header, err := rd.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	for _, name := range requiredColumns {
+		if !slices.Contains(header, name) {
+			return nil, nil, fmt.Errorf("missing column %q in header", name)
+		}
+	}
+
+	var ss []Station
+
+	for {
+		record, err := rd.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return ss, header, nil
+			}
+
+			return nil, nil, fmt.Errorf("failed to read body: %w", err)
+		}
+
+		var s Station
+		for i, v := range record {
+			if i >= len(header) {
+				break
+			}
+
+			switch header[i] {
+			case "name":
+				s.Name = v
+			case "name_kana":
+				s.NameKana = v
+			case "name_en":
+				s.NameEn = v
+			case "disambiguation":
+				s.Disambiguation = v
+			case "prefecture":
+				s.Prefecture = v
+			case "operator":
+				s.Operator = v
+			case "line":
+				s.Line = v
+			case "code":
+				s.Code = v
+			case "lat":
+				s.Lat, _ = strconv.ParseFloat(v, 64)
+			case "lon":
+				s.Lon, _ = strconv.ParseFloat(v, 64)
+			case "opened_year":
+				s.OpenedYear, _ = strconv.Atoi(v)
+			case "ja_title":
+				s.JaTitle = v
+			case "wikidata":
+				s.WikidataID = v
+			case "name_en_derived":
+				s.NameEnDerived, _ = strconv.ParseBool(v)
+			case "source":
+				s.Source = v
+			case "raw":
+				s.Raw = v
+			}
+		}
+
+		ss = append(ss, s)
+	}
+}
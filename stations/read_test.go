@@ -0,0 +1,60 @@
+package stations
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadTSVRoundTrip(t *testing.T) {
+	ss := append(slices.Clone(testStations), Station{Name: "千葉駅", NameKana: "ちば", NameEn: "Chiba", Code: "JB39", Lat: 35.613, Lon: 140.1135, OpenedYear: 1894, JaTitle: "千葉駅", WikidataID: "Q5372905", Source: "List of railway stations in Japan: C"})
+
+	for _, o := range []OutputOptions{
+		{},
+		{Columns: slices.DeleteFunc(Columns(), func(c string) bool { return c == "id" })},
+	} {
+		var buf bytes.Buffer
+		if err := o.WriteTSV(&buf, ss); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ReadTSV(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := ss
+		if o.Columns == nil {
+			// The columns not written by default read as empty.
+			want = nil
+			for _, s := range ss {
+				want = append(want, Station{Name: s.Name, NameKana: s.NameKana, NameEn: s.NameEn, Prefecture: s.Prefecture, Operator: s.Operator, Line: s.Line})
+			}
+		}
+
+		if !slices.Equal(got, want) {
+			t.Errorf("%q: got\n%+v\nwant\n%+v", o.Columns, got, want)
+		}
+	}
+}
+
+func TestReadTSVColumns(t *testing.T) {
+	// Reordered, with a column ReadTSV does not know.
+	ss, header, err := ReadTSVColumns(strings.NewReader("note\tname_en\tname\nfirst\tAkabane\t赤羽駅\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []Station{{Name: "赤羽駅", NameEn: "Akabane"}}; !slices.Equal(ss, want) {
+		t.Errorf("got %+v, want %+v", ss, want)
+	}
+
+	if want := []string{"note", "name_en", "name"}; !slices.Equal(header, want) {
+		t.Errorf("got header %q, want %q", header, want)
+	}
+
+	if _, err := ReadTSV(strings.NewReader("name\tname_kana\n赤羽駅\tあかばね\n")); err == nil || !strings.Contains(err.Error(), `missing column "name_en"`) {
+		t.Errorf("got %v, want name_en missing", err)
+	}
+}
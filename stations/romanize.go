@@ -0,0 +1,195 @@
+package stations
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// RomanizeOptions configures Romanize.
+type RomanizeOptions struct {
+	// Macrons writes the long vowels おう, おお, うう and those marked with ー
+	// with a macron (ō) rather than with two letters (ou).
+	Macrons bool
+}
+
+// Romanize fills in the empty NameEn of the stations whose NameKana IsKana
+// with its Hepburn romanization, setting NameEnDerived.
+func Romanize(stations []Station, opts RomanizeOptions) []Station {
+	ss := make([]Station, len(stations))
+
+	for i, s := range stations {
+		if s.NameEn == "" && s.NameKana != "" && IsKana(s.NameKana) {
+			s.NameEn = romanize(s.NameKana, opts.Macrons)
+			s.NameEnDerived = true
+		}
+
+		ss[i] = s
+	}
+
+	return ss
+}
+
+var (
+	kanaRomaji = map[rune]string{
+		'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+		'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+		'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+		'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+		'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+		'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+		'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+		'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+		'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+		'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+		'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+		'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+		'や': "ya", 'ゆ': "yu", 'よ': "yo",
+		'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+		'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ゔ': "vu",
+		'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+	}
+
+	// youonRomaji has the consonants of the i-row kana before a small ゃ,
+	// ゅ or ょ.
+	youonRomaji = map[rune]string{
+		'き': "ky", 'ぎ': "gy", 'し': "sh", 'じ': "j", 'ち': "ch", 'ぢ': "j",
+		'に': "ny", 'ひ': "hy", 'び': "by", 'ぴ': "py", 'み': "my", 'り': "ry",
+	}
+
+	youonVowels = map[rune]string{'ゃ': "a", 'ゅ': "u", 'ょ': "o"}
+
+	smallVowels = map[rune]string{'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o"}
+
+	macronOf = map[byte]string{'a': "ā", 'i': "ī", 'u': "ū", 'e': "ē", 'o': "ō"}
+)
+
+// syllable is the romaji of a kana syllable, with the last kana written by
+// it, or of a character that is not kana, with kana 0.
+type syllable struct {
+	kana   rune
+	romaji string
+}
+
+// romanize writes kana in modified Hepburn, capitalizing each word. Anything
+// but kana is left as it is.
+//
+// With macrons, an o-row kana followed by う or お and a u-row kana followed by
+// う are a long vowel. This reads the う of こうえん (Kōen) right but not that of
+// いのうえ (Inoue), which only a dictionary tells apart.
+func romanize(kana string, withMacrons bool) string {
+	rs := []rune(gojuonKey(kana))
+
+	// っ, ん and ー are kept as they are until the syllables around them are
+	// known.
+	var syllables []syllable
+
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+
+		var last *syllable
+		if len(syllables) > 0 {
+			last = &syllables[len(syllables)-1]
+		}
+
+		if c, ok := youonRomaji[r]; ok && i+1 < len(rs) && youonVowels[rs[i+1]] != "" {
+			syllables = append(syllables, syllable{rs[i+1], c + youonVowels[rs[i+1]]})
+			i++
+			continue
+		}
+
+		if v, ok := smallVowels[r]; ok && last != nil && last.kana != 0 && isRomajiVowel(last.romaji[len(last.romaji)-1]) {
+			// ふぁ, てぃ and the like.
+			last.kana = r
+			last.romaji = last.romaji[:len(last.romaji)-1] + v
+			continue
+		}
+
+		if s, ok := kanaRomaji[r]; ok {
+			syllables = append(syllables, syllable{r, s})
+		} else if r == 'っ' || r == 'ん' || r == 'ー' {
+			syllables = append(syllables, syllable{r, string(r)})
+		} else {
+			syllables = append(syllables, syllable{0, string(r)})
+		}
+	}
+
+	var (
+		b []byte
+		// merged tells that the syllable before was written as the macron of
+		// the one before it.
+		merged bool
+	)
+
+	for i, s := range syllables {
+		next := ""
+		if i+1 < len(syllables) {
+			next = syllables[i+1].romaji
+		}
+
+		// The vowel the syllable before ends with, if it is a kana one.
+		var vowel byte
+		if i > 0 && !merged {
+			if prev := syllables[i-1]; prev.kana != 0 && prev.kana != 'っ' && prev.kana != 'ん' && prev.kana != 'ー' {
+				vowel = prev.romaji[len(prev.romaji)-1]
+			}
+		}
+
+		merged = false
+
+		switch {
+		case s.kana == 'っ':
+			switch {
+			case strings.HasPrefix(next, "ch"):
+				b = append(b, 't')
+			case next != "" && isRomajiLetter(next[0]) && !isRomajiVowel(next[0]):
+				b = append(b, next[0])
+			}
+		case s.kana == 'ん':
+			b = append(b, 'n')
+			if next != "" && (isRomajiVowel(next[0]) || next[0] == 'y') {
+				b = append(b, '\'')
+			}
+		case s.kana == 'ー':
+			if vowel == 0 {
+				continue
+			}
+
+			switch {
+			case withMacrons:
+				b = append(b[:len(b)-1], macronOf[vowel]...)
+			case vowel == 'o':
+				b = append(b, 'u')
+			default:
+				b = append(b, vowel)
+			}
+		case withMacrons && (vowel == 'o' && (s.kana == 'う' || s.kana == 'お') || vowel == 'u' && s.kana == 'う'):
+			b = append(b[:len(b)-1], macronOf[vowel]...)
+			merged = true
+		default:
+			b = append(b, s.romaji...)
+		}
+	}
+
+	return capitalizeWords(string(b))
+}
+
+func isRomajiVowel(c byte) bool {
+	return strings.IndexByte("aiueo", c) >= 0
+}
+
+func isRomajiLetter(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+func capitalizeWords(s string) string {
+	words := strings.Split(s, " ")
+
+	for i, w := range words {
+		if r, n := utf8.DecodeRuneInString(w); n > 0 {
+			words[i] = string(unicode.ToUpper(r)) + w[n:]
+		}
+	}
+
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,49 @@
+package stations
+
+import "testing"
+
+func TestRomanize(t *testing.T) {
+	for _, tt := range []struct {
+		kana              string
+		macrons, noMacron string
+	}{
+		{"とうきょう", "Tōkyō", "Toukyou"},
+		{"トーキョー", "Tōkyō", "Toukyou"},
+		{"おおさか", "Ōsaka", "Oosaka"},
+		{"ゆうらくちょう", "Yūrakuchō", "Yuurakuchou"},
+		{"こうえん", "Kōen", "Kouen"},
+		{"すーぱー", "Sūpā", "Suupaa"},
+		{"おおおか", "Ōoka", "Oooka"},
+		{"ちばminato", "Chibaminato", "Chibaminato"},
+		{"ちばhouse", "Chibahouse", "Chibahouse"},
+		{"しんおおさか", "Shin'ōsaka", "Shin'oosaka"},
+		{"しんうらやす", "Shin'urayasu", "Shin'urayasu"},
+		{"なんば", "Nanba", "Nanba"},
+		{"ほんや", "Hon'ya", "Hon'ya"},
+		{"ろっぽんぎ", "Roppongi", "Roppongi"},
+		{"はっちょうぼり", "Hatchōbori", "Hatchoubori"},
+		{"ふぁみりー", "Famirī", "Famirii"},
+		// A dictionary would tell that this う is not a long vowel.
+		{"いのうえ", "Inōe", "Inoue"},
+	} {
+		if got := romanize(tt.kana, true); got != tt.macrons {
+			t.Errorf("romanize(%q, true) = %q, want %q", tt.kana, got, tt.macrons)
+		}
+
+		if got := romanize(tt.kana, false); got != tt.noMacron {
+			t.Errorf("romanize(%q, false) = %q, want %q", tt.kana, got, tt.noMacron)
+		}
+	}
+}
+
+func TestRomanizeStations(t *testing.T) {
+	ss := Romanize([]Station{{NameKana: "とうきょう"}, {NameKana: "しながわ", NameEn: "Shinagawa"}}, RomanizeOptions{Macrons: true})
+
+	if ss[0].NameEn != "Tōkyō" || !ss[0].NameEnDerived {
+		t.Errorf("got %+v, want Tōkyō derived", ss[0])
+	}
+
+	if ss[1].NameEn != "Shinagawa" || ss[1].NameEnDerived {
+		t.Errorf("got %+v, want the English name kept", ss[1])
+	}
+}
@@ -0,0 +1,45 @@
+package stations
+
+import (
+	"sort"
+	"strings"
+)
+
+// SortByKana sorts stations in the gojūon order of NameKana, breaking ties
+// by NameEn. Katakana and hiragana are ordered alike.
+func SortByKana(stations []Station) {
+	sort.SliceStable(stations, func(i, j int) bool {
+		ki, kj := gojuonKey(stations[i].NameKana), gojuonKey(stations[j].NameKana)
+		if ki != kj {
+			return ki < kj
+		}
+
+		return stations[i].NameEn < stations[j].NameEn
+	})
+}
+
+// gojuonKey folds katakana to hiragana. The hiragana block is laid out in
+// gojūon order with each voiced kana right after its unvoiced one, so the
+// folded strings compare in dictionary order.
+func gojuonKey(kana string) string {
+	return strings.Map(func(r rune) rune {
+		if 'ァ' <= r && r <= 'ヶ' {
+			return r - ('ァ' - 'ぁ')
+		}
+
+		return r
+	}, kana)
+}
+
+// SortByPrefecture sorts stations by Prefecture, putting those without one
+// last, and then in the order of Uniquify, which starts with NameEn.
+func SortByPrefecture(stations []Station) {
+	sort.SliceStable(stations, func(i, j int) bool {
+		pi, pj := stations[i].Prefecture, stations[j].Prefecture
+		if pi != pj {
+			return pj == "" || pi != "" && pi < pj
+		}
+
+		return lessStations(stations[i], stations[j])
+	})
+}
@@ -0,0 +1,52 @@
+package stations
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSortByKana(t *testing.T) {
+	ss := []Station{
+		{NameKana: "さいたま", NameEn: "Saitama"},
+		{NameKana: "アキハバラ", NameEn: "Akihabara"},
+		{NameKana: "あかばね", NameEn: "Akabane"},
+		{NameKana: "がくげいだいがく", NameEn: "Gakugei-daigaku"},
+		{NameKana: "かまた", NameEn: "Kamata"},
+		{NameKana: "ふちゅう", NameEn: "Fuchū (Tokyo)"},
+		{NameKana: "ふちゅう", NameEn: "Fuchū (Hiroshima)"},
+	}
+
+	SortByKana(ss)
+
+	want := []string{"Akabane", "Akihabara", "Kamata", "Gakugei-daigaku", "Saitama", "Fuchū (Hiroshima)", "Fuchū (Tokyo)"}
+	if got := namesEn(ss); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSortByPrefecture(t *testing.T) {
+	ss := []Station{
+		{NameEn: "Shinjuku", Prefecture: "Tokyo"},
+		{NameEn: "Banda"},
+		{NameEn: "Abiko", Prefecture: "Chiba"},
+		{NameEn: "Akabane", Prefecture: "Tokyo"},
+		{NameEn: "Akabane"},
+		{NameEn: "Dōgo Onsen", Prefecture: "Ehime"},
+		{NameEn: "Chiba", Prefecture: "Chiba"},
+	}
+
+	for i := 0; i < 2; i++ {
+		SortByPrefecture(ss)
+
+		want := []string{"Abiko", "Chiba", "Dōgo Onsen", "Akabane", "Shinjuku", "Akabane", "Banda"}
+		if got := namesEn(ss); !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		if got := ss[5].Prefecture; got != "" {
+			t.Errorf("got %q after the prefectures, want none", got)
+		}
+
+		slices.Reverse(ss)
+	}
+}
@@ -0,0 +1,325 @@
+// Package stations extracts the railway stations in Japan from the list
+// pages of a Wikipedia multistream dump.
+package stations
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+type Station struct {
+	Name     string `json:"name"`
+	NameKana string `json:"name_kana"`
+	NameEn   string `json:"name_en"`
+	// Disambiguation is the parenthetical, such as "Tokyo", told apart from
+	// the names by ExtractDisambiguations.
+	Disambiguation string  `json:"disambiguation,omitempty"`
+	Prefecture     string  `json:"prefecture"`
+	Operator       string  `json:"operator"`
+	Line           string  `json:"line"`
+	Code           string  `json:"code,omitempty"`
+	Lat            float64 `json:"lat,omitempty"`
+	Lon            float64 `json:"lon,omitempty"`
+	OpenedYear     int     `json:"opened_year,omitempty"`
+	JaTitle        string  `json:"ja_title,omitempty"`
+	WikidataID     string  `json:"wikidata,omitempty"`
+	// NameEnDerived is set when NameEn is romanized from NameKana rather
+	// than taken from the page.
+	NameEnDerived bool   `json:"name_en_derived,omitempty"`
+	Source        string `json:"source,omitempty"`
+	// Raw is the wikitext the row was extracted from.
+	Raw     string `json:"raw,omitempty"`
+	Article string `json:"-"`
+}
+
+// key returns the fields that identify the station for deduplication.
+func (s Station) key() Station {
+	s.JaTitle = ""
+	s.WikidataID = ""
+	s.Source = ""
+	s.Article = ""
+	s.Disambiguation = ""
+	s.Raw = ""
+	s.NameEnDerived = false
+	return s
+}
+
+// textField is a string field of a station by its column name.
+type textField struct {
+	name  string
+	value *string
+}
+
+// textFields returns the string fields of s.
+func (s *Station) textFields() []textField {
+	return []textField{
+		{"name", &s.Name},
+		{"name_kana", &s.NameKana},
+		{"name_en", &s.NameEn},
+		{"disambiguation", &s.Disambiguation},
+		{"prefecture", &s.Prefecture},
+		{"operator", &s.Operator},
+		{"line", &s.Line},
+		{"code", &s.Code},
+		{"ja_title", &s.JaTitle},
+		{"wikidata", &s.WikidataID},
+		{"source", &s.Source},
+		{"raw", &s.Raw},
+	}
+}
+
+// ID returns a number derived from the names, prefecture, operator, line and
+// code of the station, so that it stays the same across snapshots. It is the
+// FNV-1a hash of those fields cut to 63 bits to fit a signed integer.
+func (s Station) ID() int64 {
+	return hashFields(s.Name, s.NameKana, s.NameEn, s.Prefecture, s.Operator, s.Line, s.Code)
+}
+
+func hashFields(fields ...string) int64 {
+	h := fnv.New64a()
+	for _, f := range fields {
+		h.Write([]byte(f))
+		h.Write([]byte{0})
+	}
+
+	return int64(h.Sum64() & math.MaxInt64)
+}
+
+// Errors returned by Station.Validate, wrapped with the offending field.
+var (
+	ErrNoEnglishName    = errors.New("no English name")
+	ErrNonKana          = errors.New("non-kana characters")
+	ErrControlCharacter = errors.New("control characters")
+)
+
+// Validate reports whether the station is well-formed: NameEn is not empty,
+// NameKana IsKana and no field has control characters.
+func (s Station) Validate() error {
+	if s.NameEn == "" {
+		return ErrNoEnglishName
+	}
+
+	if !IsKana(s.NameKana) {
+		return fmt.Errorf("%w in name_kana", ErrNonKana)
+	}
+
+	for _, f := range []struct{ name, value string }{
+		{"name", s.Name},
+		{"name_kana", s.NameKana},
+		{"name_en", s.NameEn},
+		{"prefecture", s.Prefecture},
+		{"operator", s.Operator},
+		{"line", s.Line},
+	} {
+		if strings.IndexFunc(f.value, unicode.IsControl) >= 0 {
+			return fmt.Errorf("%w in %s", ErrControlCharacter, f.name)
+		}
+	}
+
+	return nil
+}
+
+var rowSeparatorRegexp = regexp.MustCompile(`\n\|-[^\n]*`)
+
+var (
+	// An unterminated comment runs to the end of the text as in MediaWiki.
+	commentRegexp = regexp.MustCompile(`(?s)<!--.*?(?:-->|$)`)
+	// Self-closing refs go first so that they are not taken for the start of
+	// a paired one. An unclosed <ref> is left alone rather than eating the
+	// rest of the text.
+	selfClosingRefRegexp = regexp.MustCompile(`(?i)<ref\b[^>]*/>`)
+	refRegexp            = regexp.MustCompile(`(?is)<ref\b[^>]*>.*?</ref\s*>`)
+)
+
+// cleanText removes the comments and the citations from wikitext.
+func cleanText(text string) string {
+	text = commentRegexp.ReplaceAllString(text, "")
+	text = selfClosingRefRegexp.ReplaceAllString(text, "")
+	return refRegexp.ReplaceAllString(text, "")
+}
+
+// ExtractStations matches patterns against each table row in the text of
+// pages, using the first pattern that matches the row; nil means
+// DefaultPatterns. Redirect pages are skipped, and comments and <ref> tags
+// are removed beforehand.
+func ExtractStations(pages []Page, patterns []*Pattern) []Station {
+	if patterns == nil {
+		patterns = DefaultPatterns
+	}
+
+	var stations []Station
+
+	for _, p := range pages {
+		if p.Redirect {
+			continue
+		}
+
+		for _, s := range ExtractStationsFromText(p.Revision.Text, patterns) {
+			s.Source = p.Title
+			stations = append(stations, s)
+		}
+	}
+
+	return stations
+}
+
+// ExtractStationsFromText is like ExtractStations for the wikitext of a
+// single page, leaving Source empty.
+func ExtractStationsFromText(text string, patterns []*Pattern) []Station {
+	if patterns == nil {
+		patterns = DefaultPatterns
+	}
+
+	var stations []Station
+
+	for _, row := range rowSeparatorRegexp.Split(cleanText(text), -1) {
+		stations = append(stations, extractRow(row, patterns)...)
+	}
+
+	return stations
+}
+
+func extractRow(row string, patterns []*Pattern) []Station {
+	var stations []Station
+
+	for _, pattern := range patterns {
+		matches := pattern.rx.FindAllStringSubmatch(row, -1)
+		for _, m := range matches {
+			en := submatch(m, pattern.en)
+
+			article := submatch(m, pattern.article)
+			if article == "" {
+				article = en
+			}
+
+			code := submatch(m, pattern.code)
+			cells := splitCells(submatch(m, pattern.cells))
+			if code == "" {
+				code, cells = takeCode(cells)
+			}
+
+			stations = append(stations, Station{
+				Name:       submatch(m, pattern.ja),
+				NameKana:   submatch(m, pattern.kana),
+				NameEn:     en,
+				Prefecture: cellAt(cells, prefectureColumn),
+				Operator:   cellAt(cells, operatorColumn),
+				Line:       cellAt(cells, lineColumn),
+				Code:       code,
+				JaTitle:    submatch(m, pattern.jaTitle),
+				Article:    article,
+				Raw:        m[0],
+			})
+		}
+
+		if len(matches) > 0 {
+			break
+		}
+	}
+
+	return stations
+}
+
+// Columns following the Japanese name cell, in the order they appear in the
+// station tables. Any of them may be missing.
+const (
+	prefectureColumn = iota
+	operatorColumn
+	lineColumn
+)
+
+var linkRegexp = regexp.MustCompile(`\[\[(?:[^|\]]+\|)?([^]]+)]]`)
+
+func splitCells(row string) []string {
+	if row == "" {
+		return nil
+	}
+
+	cells := strings.Split(strings.ReplaceAll(row, "\n|", "||"), "||")
+	for i, c := range cells {
+		cells[i] = strings.TrimSpace(linkRegexp.ReplaceAllString(c, "$1"))
+	}
+
+	return cells
+}
+
+// stationCodeRegexp matches a station number such as JY17 or M-08.
+var stationCodeRegexp = regexp.MustCompile(`^[A-Z]{1,3}-?[0-9]{1,3}$`)
+
+// takeCode removes the first cell holding a station number from cells and
+// returns it, so that the other columns keep their positions.
+func takeCode(cells []string) (string, []string) {
+	for i, c := range cells {
+		if stationCodeRegexp.MatchString(c) {
+			return c, append(cells[:i:i], cells[i+1:]...)
+		}
+	}
+
+	return "", cells
+}
+
+func cellAt(cells []string, i int) string {
+	if i < len(cells) {
+		return cells[i]
+	}
+
+	return ""
+}
+
+// DisambiguationRegexp matches what RemoveDisambiguations strips from the
+// names: everything from the first parenthesis on, with the text in the
+// parentheses as the first group.
+var DisambiguationRegexp = regexp.MustCompile(`\s*[(（]([^）)]*)[）)].*`)
+
+// TrailingDisambiguationRegexp is like DisambiguationRegexp but only matches
+// the parenthetical ending a name, so that the parentheses within a name and
+// the text after them are kept.
+var TrailingDisambiguationRegexp = regexp.MustCompile(`\s*[(（]([^（）()]*)[）)]\s*$`)
+
+func RemoveDisambiguations(stations []Station) []Station {
+	return RemoveDisambiguationsMatching(stations, DisambiguationRegexp)
+}
+
+// RemoveDisambiguationsMatching is like RemoveDisambiguations but strips what
+// rx matches.
+func RemoveDisambiguationsMatching(stations []Station, rx *regexp.Regexp) []Station {
+	ss := make([]Station, len(stations))
+
+	for i, s := range stations {
+		s.Name = rx.ReplaceAllString(s.Name, "")
+		s.NameKana = rx.ReplaceAllString(s.NameKana, "")
+		s.NameEn = rx.ReplaceAllString(s.NameEn, "")
+		ss[i] = s
+	}
+
+	return ss
+}
+
+// ExtractDisambiguations is like RemoveDisambiguations but keeps the text in
+// the parentheses of NameEn, or else of Name, in Disambiguation.
+func ExtractDisambiguations(stations []Station) []Station {
+	return ExtractDisambiguationsMatching(stations, DisambiguationRegexp)
+}
+
+// ExtractDisambiguationsMatching is like ExtractDisambiguations but strips
+// what rx matches, keeping its first group, or the whole match if it has
+// none.
+func ExtractDisambiguationsMatching(stations []Station, rx *regexp.Regexp) []Station {
+	ss := RemoveDisambiguationsMatching(stations, rx)
+
+	for i, s := range stations {
+		for _, name := range []string{s.NameEn, s.Name} {
+			if m := rx.FindStringSubmatch(name); m != nil {
+				ss[i].Disambiguation = strings.TrimSpace(m[min(1, len(m)-1)])
+				break
+			}
+		}
+	}
+
+	return ss
+}
@@ -0,0 +1,325 @@
+package stations
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRemoveDisambiguationsMatching(t *testing.T) {
+	for _, tt := range []struct {
+		rx         *regexp.Regexp
+		name, want string
+	}{
+		{DisambiguationRegexp, "Foo (A) Bar", "Foo"},
+		{DisambiguationRegexp, "Fuchū (Tokyo)", "Fuchū"},
+		{DisambiguationRegexp, "府中駅（東京都）", "府中駅"},
+		{TrailingDisambiguationRegexp, "Foo (A) Bar", "Foo (A) Bar"},
+		{TrailingDisambiguationRegexp, "Fuchū (Tokyo)", "Fuchū"},
+		{TrailingDisambiguationRegexp, "Foo (A) Bar (B)", "Foo (A) Bar"},
+		{TrailingDisambiguationRegexp, "府中駅（東京都）", "府中駅"},
+	} {
+		ss := RemoveDisambiguationsMatching([]Station{{NameEn: tt.name}}, tt.rx)
+		if got := ss[0].NameEn; got != tt.want {
+			t.Errorf("%s on %q: got %q, want %q", tt.rx, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractDisambiguationsMatching(t *testing.T) {
+	for _, tt := range []struct {
+		rx                     *regexp.Regexp
+		name, want, wantDisamb string
+	}{
+		{DisambiguationRegexp, "Foo (A) Bar", "Foo", "A"},
+		{TrailingDisambiguationRegexp, "Foo (A) Bar", "Foo (A) Bar", ""},
+		{TrailingDisambiguationRegexp, "Foo (A) Bar (B)", "Foo (A) Bar", "B"},
+	} {
+		ss := ExtractDisambiguationsMatching([]Station{{NameEn: tt.name}}, tt.rx)
+		if got := ss[0]; got.NameEn != tt.want || got.Disambiguation != tt.wantDisamb {
+			t.Errorf("%s on %q: got %q (%q), want %q (%q)", tt.rx, tt.name, got.NameEn, got.Disambiguation, tt.want, tt.wantDisamb)
+		}
+	}
+}
+
+func testPages(text string) []Page {
+	return []Page{{Title: "List of railway stations in Japan: A", Revision: Revision{Text: text}}}
+}
+
+func TestExtractStationsPrefecture(t *testing.T) {
+	for _, tt := range []struct {
+		text, want string
+	}{
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || [[Tokyo]] || JR East", "Tokyo"},
+		{"|[[Abiko Station (Chiba)|Abiko]] ||[[:ja:我孫子駅 (千葉県)|我孫子駅]]（あびこ） || [[Chiba Prefecture|Chiba]]", "Chiba"},
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね）", ""},
+	} {
+		ss := ExtractStations(testPages(tt.text), nil)
+		if len(ss) != 1 || ss[0].Prefecture != tt.want {
+			t.Errorf("%q: got %+v, want prefecture %q", tt.text, ss, tt.want)
+		}
+	}
+}
+
+func TestExtractStationsOperator(t *testing.T) {
+	for _, tt := range []struct {
+		text, want string
+	}{
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || [[Tokyo]] || [[East Japan Railway Company|JR East]]", "JR East"},
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || Tokyo || Tokyo Metro", "Tokyo Metro"},
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || Tokyo", ""},
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね）", ""},
+	} {
+		ss := ExtractStations(testPages(tt.text), nil)
+		if len(ss) != 1 || ss[0].Operator != tt.want {
+			t.Errorf("%q: got %+v, want operator %q", tt.text, ss, tt.want)
+		}
+	}
+}
+
+func TestExtractStationsLine(t *testing.T) {
+	for _, tt := range []struct {
+		text, want string
+	}{
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || [[Tokyo]] || JR East || [[Keihin-Tōhoku Line]]", "Keihin-Tōhoku Line"},
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || Tokyo || JR East || [[Saikyō Line|Saikyō]]", "Saikyō"},
+		// One cell per line.
+		{"|-\n|[[Daikanyama Station|Daikanyama]]\n|[[:ja:代官山駅|代官山駅]]（だいかんやま）\n|[[Tokyo]]\n|Tokyu\n|[[Tōyoko Line]]\n|-", "Tōyoko Line"},
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || Tokyo || JR East", ""},
+	} {
+		ss := ExtractStations(testPages(tt.text), nil)
+		if len(ss) != 1 || ss[0].Line != tt.want {
+			t.Errorf("%q: got %+v, want line %q", tt.text, ss, tt.want)
+		}
+	}
+}
+
+func TestCleanText(t *testing.T) {
+	for _, tt := range []struct {
+		text, want string
+	}{
+		{"Akabane<ref>JR East</ref> Station", "Akabane Station"},
+		{`Akabane<ref name="a">JR<br/>East</ref> Station`, "Akabane Station"},
+		{`Akabane<ref name="a" /> Station`, "Akabane Station"},
+		{"Akabane<REF>x</REF > Station", "Akabane Station"},
+		{"Akabane<!-- JR East --> Station", "Akabane Station"},
+		{"Akabane<!-- <ref>x</ref> --> Station", "Akabane Station"},
+		{"Akabane<ref>a</ref>, Ikebukuro<ref>b</ref>", "Akabane, Ikebukuro"},
+		// An unterminated comment runs to the end of the text, but an unclosed
+		// ref is left alone.
+		{"Akabane<!-- unterminated", "Akabane"},
+		{"Akabane<ref>unclosed", "Akabane<ref>unclosed"},
+		{"Akabane<references/>", "Akabane<references/>"},
+	} {
+		if got := cleanText(tt.text); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestExtractStationsRef(t *testing.T) {
+	text := "|[[Akabane Station|Akabane]]<ref>{{cite web|title=Akabane Station|url=https://example.com/}}</ref> ||[[:ja:赤羽駅|赤羽駅]]（あかばね）<!-- verify --> || Tokyo"
+
+	pages := []Page{{Title: "A"}}
+	pages[0].Revision.Text = text
+
+	ss := ExtractStations(pages, nil)
+	if len(ss) != 1 || ss[0].NameEn != "Akabane" || ss[0].NameKana != "あかばね" || ss[0].Prefecture != "Tokyo" {
+		t.Errorf("got %+v, want Akabane unpolluted by the ref and the comment", ss)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		s    Station
+		want error
+		msg  string
+	}{
+		{Station{Name: "赤羽駅", NameKana: "あかばね", NameEn: "Akabane"}, nil, ""},
+		{Station{NameKana: "あかばね"}, ErrNoEnglishName, "no English name"},
+		{Station{NameKana: "ちばminato", NameEn: "Chiba-minato"}, ErrNonKana, "non-kana characters in name_kana"},
+		{Station{Name: "赤羽\t駅", NameEn: "Akabane"}, ErrControlCharacter, "control characters in name"},
+		{Station{NameEn: "Akabane\n"}, ErrControlCharacter, "control characters in name_en"},
+		{Station{NameEn: "Akabane", Line: "Saikyō\x00Line"}, ErrControlCharacter, "control characters in line"},
+	} {
+		err := tt.s.Validate()
+		if !errors.Is(err, tt.want) || err != nil && err.Error() != tt.msg {
+			t.Errorf("%+v: got %v, want %v with %q", tt.s, err, tt.want, tt.msg)
+		}
+	}
+}
+
+// akabaneRow is a row of a station table with all the cells after the names.
+const akabaneRow = `|-
+|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || JK38 || [[Tokyo]] || [[East Japan Railway Company|JR East]] || [[Keihin-Tōhoku Line]]
+|-`
+
+func TestExtractStationsFromText(t *testing.T) {
+	for _, tt := range []struct {
+		text          string
+		en, article   string
+		name, kana    string
+		wantNoStation bool
+	}{
+		{text: akabaneRow, en: "Akabane", article: "Akabane Station", name: "赤羽駅", kana: "あかばね"},
+		{text: "|[[Banda Station]] ||[[:ja:番田駅|番田駅]]（ばんだ）", en: "Banda Station", article: "Banda Station", name: "番田駅", kana: "ばんだ"},
+		{text: "|[[Abiko Station (Chiba)|Abiko (Chiba)]] ||[[:ja:我孫子駅 (千葉県)|我孫子駅]]（あびこ）", en: "Abiko (Chiba)", article: "Abiko Station (Chiba)", name: "我孫子駅", kana: "あびこ"},
+		{text: "{| class=\"wikitable\"\n! Name !! Japanese !! Prefecture\n|}", wantNoStation: true},
+		{text: "|[[Akabane Station|Akabane]] || Tokyo", wantNoStation: true},
+	} {
+		ss := ExtractStationsFromText(tt.text, nil)
+		if tt.wantNoStation {
+			if len(ss) != 0 {
+				t.Errorf("%q: got %+v, want no station", tt.text, ss)
+			}
+			continue
+		}
+
+		if len(ss) != 1 {
+			t.Errorf("%q: got %+v, want one station", tt.text, ss)
+			continue
+		}
+
+		if s := ss[0]; s.NameEn != tt.en || s.Article != tt.article || s.Name != tt.name || s.NameKana != tt.kana || s.Source != "" {
+			t.Errorf("%q: got %+v, want %s (%s) %s（%s）", tt.text, s, tt.en, tt.article, tt.name, tt.kana)
+		}
+	}
+
+	// Rows one per line without separators are matched one by one.
+	text := "|[[Banda Station]] ||[[:ja:番田駅|番田駅]]（ばんだ）\n|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね）"
+	if ss := ExtractStationsFromText(text, nil); len(ss) != 2 || ss[0].NameEn != "Banda Station" || ss[1].NameEn != "Akabane" {
+		t.Errorf("got %+v, want Banda Station and Akabane", ss)
+	}
+}
+
+func BenchmarkExtractStationsFromText(b *testing.B) {
+	text := "{| class=\"wikitable\"\n" + strings.Repeat(akabaneRow[:len(akabaneRow)-len("|-")], 1000) + "|}"
+
+	b.SetBytes(int64(len(text)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if ss := ExtractStationsFromText(text, nil); len(ss) != 1000 {
+			b.Fatalf("got %d stations, want 1000", len(ss))
+		}
+	}
+}
+
+func TestRegexpsCompiledOnce(t *testing.T) {
+	// Compiling the regexps used costs more allocations than extracting a
+	// row and removing its disambiguation do in all.
+	compile := testing.AllocsPerRun(10, func() {
+		for _, p := range DefaultPatterns {
+			regexp.MustCompile(p.rx.String())
+		}
+		regexp.MustCompile(DisambiguationRegexp.String())
+	})
+
+	extract := testing.AllocsPerRun(10, func() {
+		RemoveDisambiguations(ExtractStationsFromText(akabaneRow, nil))
+	})
+
+	if extract >= compile {
+		t.Errorf("got %v allocations extracting, want fewer than the %v of compiling the regexps", extract, compile)
+	}
+}
+
+func TestExtractStationsCode(t *testing.T) {
+	for _, tt := range []struct {
+		text, code, prefecture string
+	}{
+		{akabaneRow, "JK38", "Tokyo"},
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || Tokyo || JR East", "", "Tokyo"},
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || M-08 || Tokyo", "M-08", "Tokyo"},
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || Tokyo || JY17", "JY17", "Tokyo"},
+		// Neither is a code: too many letters, and lower case.
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || JRKE38 || Tokyo", "", "JRKE38"},
+		{"|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || jk38 || Tokyo", "", "jk38"},
+	} {
+		ss := ExtractStationsFromText(tt.text, nil)
+		if len(ss) != 1 || ss[0].Code != tt.code || ss[0].Prefecture != tt.prefecture {
+			t.Errorf("%q: got %+v, want code %q in %s", tt.text, ss, tt.code, tt.prefecture)
+		}
+	}
+
+	// A station has a code per line, so the codes keep the stations apart.
+	jk := ExtractStationsFromText(akabaneRow, nil)[0]
+	ja := jk
+	ja.Code = "JA15"
+	if got := Uniquify([]Station{jk, ja}); len(got) != 2 {
+		t.Errorf("got %+v, want the stations of both codes", got)
+	}
+
+	if b, err := json.Marshal(Station{NameEn: "Akabane"}); err != nil || strings.Contains(string(b), "code") {
+		t.Errorf("got %s, %v, want no code", b, err)
+	}
+}
+
+func TestExtractStationsJaTitle(t *testing.T) {
+	for _, tt := range []struct {
+		text, name, jaTitle string
+	}{
+		{akabaneRow, "赤羽駅", "赤羽駅"},
+		{"|[[Abiko Station (Chiba)|Abiko]] ||[[:ja:我孫子駅 (千葉県)|我孫子駅]]（あびこ）", "我孫子駅", "我孫子駅 (千葉県)"},
+		{"|[[Akabane Station|Akabane]] || 赤羽駅（あかばね） || Tokyo", "赤羽駅", ""},
+	} {
+		ss := ExtractStationsFromText(tt.text, nil)
+		if len(ss) != 1 || ss[0].Name != tt.name || ss[0].JaTitle != tt.jaTitle {
+			t.Errorf("%q: got %+v, want %s linking to %q", tt.text, ss, tt.name, tt.jaTitle)
+		}
+	}
+
+	// The stations linking to different articles are the same by default.
+	a := Station{Name: "我孫子駅", NameKana: "あびこ", NameEn: "Abiko", JaTitle: "我孫子駅 (千葉県)"}
+	b := a
+	b.JaTitle = "我孫子駅"
+	if got := Uniquify([]Station{a, b}); len(got) != 1 {
+		t.Errorf("got %+v, want one station", got)
+	}
+
+	if got := (DedupOptions{Fields: []string{"name", "ja_title"}}).Uniquify([]Station{a, b}); len(got) != 2 {
+		t.Errorf("got %+v, want both with ja_title compared", got)
+	}
+}
+
+func TestExtractDisambiguations(t *testing.T) {
+	ss := ExtractDisambiguations([]Station{
+		{Name: "我孫子駅", NameEn: "Abiko (Chiba)"},
+		{Name: "山下駅 (東京都)", NameEn: "Yamashita"},
+		{Name: "赤羽駅", NameEn: "Akabane"},
+	})
+
+	for i, want := range []Station{
+		{Name: "我孫子駅", NameEn: "Abiko", Disambiguation: "Chiba"},
+		{Name: "山下駅", NameEn: "Yamashita", Disambiguation: "東京都"},
+		{Name: "赤羽駅", NameEn: "Akabane"},
+	} {
+		if ss[i] != want {
+			t.Errorf("got %+v, want %+v", ss[i], want)
+		}
+	}
+}
+
+func TestExtractStationsRaw(t *testing.T) {
+	ss := ExtractStationsFromText("{| class=\"wikitable\"\n"+akabaneRow+"\n|}", nil)
+	if len(ss) != 1 {
+		t.Fatalf("got %+v, want Akabane", ss)
+	}
+
+	if want := "|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || JK38 || [[Tokyo]] || [[East Japan Railway Company|JR East]] || [[Keihin-Tōhoku Line]]"; ss[0].Raw != want {
+		t.Errorf("got raw %q, want %q", ss[0].Raw, want)
+	}
+
+	// The same station matched from other wikitext is no other station.
+	other := ss[0]
+	other.Raw = "|[[Akabane Station|Akabane]]  ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || JK38 || [[Tokyo]] || [[East Japan Railway Company|JR East]] || [[Keihin-Tōhoku Line]]"
+	if got := Uniquify([]Station{ss[0], other}); len(got) != 1 {
+		t.Errorf("got %+v, want one station", got)
+	}
+
+	if a, b := ss[0].ID(), other.ID(); a != b {
+		t.Errorf("got IDs %d and %d, want the same", a, b)
+	}
+}
@@ -0,0 +1,45 @@
+# gen.py writes multistream.xml.bz2 and multistream-index.txt.bz2, a
+# multistream dump with the header and the footer of a real dump around its
+# blocks of pages, and its index. Run it in this directory to regenerate them.
+import bz2
+pages = [
+ [(10,"Other page","nothing here")],
+ [(20,"List of railway stations in Japan: A","""{| class="wikitable"
+|-
+|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || JK38 || [[Tokyo]] || [[East Japan Railway Company|JR East]] || [[Keihin-Tōhoku Line]]
+|-
+|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね） || [[Tokyo]] || JR East || [[Saikyō Line]]
+|-
+|[[Abiko Station (Chiba)|Abiko (Chiba)]] ||[[:ja:我孫子駅 (千葉県)|我孫子駅]]（あびこ） || [[Chiba Prefecture|Chiba]] || JR East || [[Jōban Line]]
+|}"""),(21,"Unrelated","x"),(22,"List of railway stations in Japan: E","  #redirect [[List of railway stations in Japan: A]]\n|[[Bogus Station|Bogus]] ||[[:ja:偽駅|偽駅]]（にせ）")],
+ [(30,"Something","y")],
+ [(40,"List of railway stations in Japan: B","""|[[Banda Station]] ||[[:ja:番田駅|番田駅]]（ばんだ）
+|[[Akabane Station|Akabane]] ||[[:ja:赤羽駅|赤羽駅]]（あかばね）
+|[[Banda Station|Banda]] ||[[:ja:番田駅|番田駅]]（ばんだ）
+|[[Banda Station]] ||[[:ja:番田駅|番田駅]]（ばんだ）"""),
+  (41,"List of railway stations in Japan: C","""|[[Chiba Station|Chiba]] ||[[:ja:千葉駅|千葉駅]]（ちば）
+|[[Chiba-minato Station|Chiba-minato]] ||[[:ja:千葉みなと駅|千葉みなと駅]]（ちばminato）
+|[[Chiba Station|chiba]] ||[[:ja:千葉駅|千葉駅]]（ちば）""")],
+ [(45,"List of railway stations in Japan: D","""{| class="wikitable"
+|-
+|[[Daikanyama Station|Daikanyama]]
+|[[:ja:代官山駅|代官山駅]]（だいかんやま）
+|[[Tokyo]]
+|Tokyu
+|[[Tōyoko Line]]
+|-
+|[[Dōgo Onsen Station]] || 道後温泉駅（どうごおんせん） || Ehime
+|}""")],
+ [(50,"Zzz","z"),(51,"Akabane Station","{{Infobox station|coordinates = {{coord|35|46|40|N|139|43|15|E|display=inline}}\n| opened = {{Start date|1885|3|1}}}}")],
+ [(60,"Chiba Station","{{coord|35.6130|140.1135}}"),(61,"List of railway stations in Japan: F","|[[Fuchū Station (Tokyo)|Fuchū]] ||[[:ja:府中駅 (東京都)|府中駅]]（ふちゅう） || [[Tokyo]] || Keio || [[Keio Line]]")],
+]
+from xml.sax.saxutils import escape
+dump=bz2.compress(b"<mediawiki>\n  <siteinfo><sitename>Wikipedia</sitename></siteinfo>\n"); idx=[]
+for blk in pages:
+    off=len(dump)
+    xml="".join(f"<page><title>{escape(t)}</title><id>{i}</id><revision><text>{escape(x)}</text></revision></page>\n" for i,t,x in blk)
+    dump+=bz2.compress(xml.encode())
+    for i,t,x in blk: idx.append(f"{off}:{i}:{t}")
+dump+=bz2.compress(b"</mediawiki>\n")
+open("multistream.xml.bz2","wb").write(dump)
+open("multistream-index.txt.bz2","wb").write(bz2.compress(("\n".join(idx)+"\n").encode()))
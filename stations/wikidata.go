@@ -0,0 +1,61 @@
+package stations
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Sitelink is a page of a wiki, such as jawiki or enwiki, linked to a
+// Wikidata item.
+type Sitelink struct {
+	Site  string
+	Title string
+}
+
+// ReadSitelinks reads the Wikidata item IDs of the pages from lines of
+// tab-separated item ID (with or without the Q), site and title, the columns
+// of the wb_items_per_site table. Only the sitelinks satisfying keep are
+// kept, so that the whole table need not fit in memory.
+func ReadSitelinks(r io.Reader, keep func(Sitelink) bool) (map[Sitelink]string, error) {
+	links := make(map[Sitelink]string)
+
+	br := bufio.NewReader(r)
+
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read line: %w", err)
+		}
+
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			records := strings.SplitN(line, "\t", 3)
+			if len(records) < 3 {
+				return nil, fmt.Errorf("line %d has %d columns instead of 3", n, len(records))
+			}
+
+			l := Sitelink{Site: records[1], Title: records[2]}
+			if keep(l) {
+				links[l] = "Q" + strings.TrimPrefix(records[0], "Q")
+			}
+		}
+
+		if err != nil {
+			return links, nil
+		}
+	}
+}
+
+// ResolveWikidata fills WikidataID of each station from links, looking up
+// JaTitle on jawiki and then Article on enwiki.
+func ResolveWikidata(stations []Station, links map[Sitelink]string) {
+	for i, s := range stations {
+		if id, ok := links[Sitelink{"jawiki", s.JaTitle}]; ok && s.JaTitle != "" {
+			stations[i].WikidataID = id
+		} else if id, ok := links[Sitelink{"enwiki", s.Article}]; ok && s.Article != "" {
+			stations[i].WikidataID = id
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package stations
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func readSitelinks(t *testing.T, keep func(Sitelink) bool) map[Sitelink]string {
+	t.Helper()
+
+	f, err := os.Open("testdata/sitelinks.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	links, err := ReadSitelinks(f, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return links
+}
+
+func TestReadSitelinks(t *testing.T) {
+	links := readSitelinks(t, func(l Sitelink) bool { return l.Site == "jawiki" })
+
+	if len(links) != 4 {
+		t.Errorf("got %v, want the 4 jawiki sitelinks", links)
+	}
+
+	// The IDs are written with a Q whether the table has it or not.
+	for l, want := range map[Sitelink]string{
+		{"jawiki", "赤羽駅"}:        "Q801188",
+		{"jawiki", "我孫子駅 (千葉県)"}: "Q1193537",
+	} {
+		if got := links[l]; got != want {
+			t.Errorf("%v: got %q, want %q", l, got, want)
+		}
+	}
+
+	if _, err := ReadSitelinks(strings.NewReader("Q1\tjawiki\n"), func(Sitelink) bool { return true }); err == nil {
+		t.Error("got no error for a line of 2 columns")
+	}
+}
+
+func TestResolveWikidata(t *testing.T) {
+	links := readSitelinks(t, func(Sitelink) bool { return true })
+
+	ss := []Station{
+		{NameEn: "Akabane", JaTitle: "赤羽駅", Article: "Akabane Station"},
+		{NameEn: "Abiko", JaTitle: "我孫子駅 (千葉県)"},
+		// The English article is used without a Japanese one.
+		{NameEn: "Chiba", Article: "Chiba Station"},
+		{NameEn: "Zzz", JaTitle: "Zzz駅", Article: "Zzz Station"},
+		{NameEn: "Empty"},
+	}
+
+	ResolveWikidata(ss, links)
+
+	for i, want := range []string{"Q801188", "Q1193537", "Q5372905", "", ""} {
+		if got := ss[i].WikidataID; got != want {
+			t.Errorf("%s: got %q, want %q", ss[i].NameEn, got, want)
+		}
+	}
+}
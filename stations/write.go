@@ -0,0 +1,643 @@
+package stations
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/width"
+)
+
+// SchemaVersion is the version of the fields written, bumped whenever Station
+// gains or loses one: 2 added name_en_derived, 3 disambiguation and 4 raw.
+const SchemaVersion = 4
+
+// OutputOptions configures the writers.
+type OutputOptions struct {
+	// Provenance includes Source in the output.
+	Provenance bool
+	// Coordinates adds the lat and lon columns to the default columns, for
+	// the stations whose coordinates were resolved.
+	Coordinates bool
+	// Columns lists the columns to write in order, overriding Provenance;
+	// nil means the default columns. See Columns for the valid names.
+	Columns []string
+	// Missing replaces the empty names, prefectures, operators and lines, and
+	// in TSV the unknown values as well.
+	Missing string
+	// OmitEmpty leaves the empty and unknown values out of JSON.
+	OmitEmpty bool
+	// WithID adds the id column as the first of the default columns.
+	WithID bool
+	// Dedup derives the id column with DedupOptions.ID, so that the stations
+	// told apart in deduplication get distinct IDs.
+	Dedup DedupOptions
+	// Disambiguation adds the disambiguation column after name_en to the
+	// default columns.
+	Disambiguation bool
+	// Raw includes the wikitext each station was extracted from in the
+	// output, for debugging the patterns.
+	Raw bool
+	// NullGeometry keeps the stations without coordinates in GeoJSON as
+	// features without a geometry.
+	NullGeometry bool
+	// Headers renames the columns in the TSV header, from the column names to
+	// the labels.
+	Headers map[string]string
+	// Schema wraps the JSON, though not NDJSON, in an object with
+	// SchemaVersion, GeneratedFrom and the stations, and starts TSV with a
+	// comment line carrying the first two.
+	Schema bool
+	// GeneratedFrom names what the stations were extracted from, such as the
+	// dump date.
+	GeneratedFrom string
+}
+
+// column is a field of Station as written by the writers. value returns nil
+// for an unknown value.
+type column struct {
+	name  string
+	value func(s Station) any
+}
+
+var columns = []column{
+	{"id", func(s Station) any { return s.ID() }},
+	{"name", func(s Station) any { return s.Name }},
+	{"name_kana", func(s Station) any { return s.NameKana }},
+	{"name_en", func(s Station) any { return s.NameEn }},
+	{"disambiguation", func(s Station) any { return s.Disambiguation }},
+	{"prefecture", func(s Station) any { return s.Prefecture }},
+	{"operator", func(s Station) any { return s.Operator }},
+	{"line", func(s Station) any { return s.Line }},
+	{"code", func(s Station) any { return s.Code }},
+	{"lat", func(s Station) any { return coordinate(s.Lat) }},
+	{"lon", func(s Station) any { return coordinate(s.Lon) }},
+	{"opened_year", func(s Station) any {
+		if s.OpenedYear == 0 {
+			return nil
+		}
+		return s.OpenedYear
+	}},
+	{"ja_title", func(s Station) any { return s.JaTitle }},
+	{"wikidata", func(s Station) any { return s.WikidataID }},
+	{"name_en_derived", func(s Station) any {
+		if !s.NameEnDerived {
+			return nil
+		}
+		return true
+	}},
+	{"source", func(s Station) any { return s.Source }},
+	{"raw", func(s Station) any { return s.Raw }},
+}
+
+func coordinate(f float64) any {
+	if f == 0 {
+		return nil
+	}
+
+	return f
+}
+
+// omitEmpty has the string columns whose field in Station is omitempty in
+// JSON.
+var omitEmpty = map[string]bool{"disambiguation": true, "code": true, "ja_title": true, "wikidata": true, "source": true, "raw": true}
+
+// Columns returns the valid column names.
+func Columns() []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+
+	return names
+}
+
+// CheckColumns returns an error naming the valid columns if any of names is
+// not one.
+func CheckColumns(names []string) error {
+	_, err := lookupColumns(names)
+	return err
+}
+
+func lookupColumns(names []string) ([]column, error) {
+	cs := make([]column, 0, len(names))
+
+	for _, name := range names {
+		i := slices.IndexFunc(columns, func(c column) bool { return c.name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown column %q (valid columns: %s)", name, strings.Join(Columns(), ", "))
+		}
+
+		cs = append(cs, columns[i])
+	}
+
+	return cs, nil
+}
+
+// columns returns the columns to write.
+func (o OutputOptions) columns() ([]column, error) {
+	if o.Columns != nil {
+		return o.lookupColumns(o.Columns)
+	}
+
+	names := []string{"name", "name_kana", "name_en", "prefecture", "operator", "line"}
+	if o.Coordinates {
+		names = append(names, "lat", "lon")
+	}
+	if o.Disambiguation {
+		names = slices.Insert(names, slices.Index(names, "name_en")+1, "disambiguation")
+	}
+	if o.WithID {
+		names = append([]string{"id"}, names...)
+	}
+	if o.Provenance {
+		names = append(names, "source")
+	}
+	if o.Raw {
+		names = append(names, "raw")
+	}
+
+	return o.lookupColumns(names)
+}
+
+// missingColumns are the columns whose empty values Missing replaces.
+var missingColumns = []string{"name", "name_kana", "name_en", "prefecture", "operator", "line"}
+
+// lookupColumns is like lookupColumns with the id column derived with
+// o.Dedup and Missing in the empty missingColumns.
+func (o OutputOptions) lookupColumns(names []string) ([]column, error) {
+	cs, err := lookupColumns(names)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, c := range cs {
+		value := c.value
+		switch {
+		case c.name == "id":
+			cs[i].value = func(s Station) any { return o.Dedup.ID(s) }
+		case o.Missing != "" && slices.Contains(missingColumns, c.name):
+			cs[i].value = func(s Station) any {
+				if v := value(s); v != "" {
+					return v
+				}
+				return o.Missing
+			}
+		}
+	}
+
+	return cs, nil
+}
+
+func (o OutputOptions) header(cs []column) []string {
+	header := make([]string, len(cs))
+	for i, c := range cs {
+		if label, ok := o.Headers[c.name]; ok {
+			header[i] = label
+		} else {
+			header[i] = c.name
+		}
+	}
+
+	return header
+}
+
+func WriteTSV(w io.Writer, stations []Station) error {
+	return OutputOptions{}.WriteTSV(w, stations)
+}
+
+func WriteJSON(w io.Writer, stations []Station) error {
+	return OutputOptions{}.WriteJSON(w, stations)
+}
+
+func WriteNDJSON(w io.Writer, stations []Station) error {
+	return OutputOptions{}.WriteNDJSON(w, stations)
+}
+
+func (o OutputOptions) WriteTSV(w io.Writer, stations []Station) error {
+	return o.writeTSV(w, stations, nil)
+}
+
+// WriteRejectsTSV is like WriteTSV with a trailing reason column holding
+// reasons[i] for stations[i].
+func (o OutputOptions) WriteRejectsTSV(w io.Writer, stations []Station, reasons []string) error {
+	if reasons == nil {
+		reasons = []string{}
+	}
+
+	return o.writeTSV(w, stations, reasons)
+}
+
+// writeTSV writes the reason column as well unless reasons is nil.
+func (o OutputOptions) writeTSV(w io.Writer, stations []Station, reasons []string) error {
+	stations = o.prepare(stations)
+
+	cs, err := o.columns()
+	if err != nil {
+		return err
+	}
+
+	if o.Schema {
+		if _, err := fmt.Fprintf(w, "# schema_version=%d generated_from=%s\n", SchemaVersion, o.GeneratedFrom); err != nil {
+			return fmt.Errorf("failed to write schema version: %w", err)
+		}
+	}
+
+	wr := csv.NewWriter(w)
+	wr.Comma = '\t'
+
+	header := o.header(cs)
+	if reasons != nil {
+		header = append(header, "reason")
+	}
+
+	if err := wr.Write(header); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	for j, s := range stations {
+		record := make([]string, len(cs), len(cs)+1)
+		for i, c := range cs {
+			if record[i] = formatValue(c.value(s)); record[i] == "" {
+				record[i] = o.Missing
+			}
+		}
+		if reasons != nil {
+			record = append(record, reasons[j])
+		}
+
+		if err := wr.Write(record); err != nil {
+			return fmt.Errorf("failed to write body: %w", err)
+		}
+	}
+
+	wr.Flush()
+
+	if err := wr.Error(); err != nil {
+		return fmt.Errorf("failed to flush: %w", err)
+	}
+
+	return nil
+}
+
+func WriteTable(w io.Writer, stations []Station) error {
+	return OutputOptions{}.WriteTable(w, stations)
+}
+
+// WriteTable writes the columns of WriteTSV padded to line up in a terminal,
+// counting East Asian wide characters as two columns.
+func (o OutputOptions) WriteTable(w io.Writer, stations []Station) error {
+	stations = o.prepare(stations)
+
+	cs, err := o.columns()
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(stations)+1)
+	rows = append(rows, o.header(cs))
+
+	for _, s := range stations {
+		row := make([]string, len(cs))
+		for i, c := range cs {
+			if row[i] = formatValue(c.value(s)); row[i] == "" {
+				row[i] = o.Missing
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	widths := make([]int, len(cs))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], displayWidth(cell))
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+
+	for _, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			line.WriteString(cell)
+			if i < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)+2))
+			}
+		}
+
+		bw.WriteString(strings.TrimRight(line.String(), " "))
+		bw.WriteByte('\n')
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write table: %w", err)
+	}
+
+	return nil
+}
+
+func displayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		switch width.LookupRune(r).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			n += 2
+		default:
+			n++
+		}
+	}
+
+	return n
+}
+
+// WriteIndexTSV writes the entries of index ordered by offset, keeping the
+// order of the index within a block.
+func WriteIndexTSV(w io.Writer, index *Index) error {
+	wr := csv.NewWriter(w)
+	wr.Comma = '\t'
+
+	if err := wr.Write([]string{"id", "title", "offset"}); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	offsets := make([]int64, 0, len(index.OnDump))
+	for offset := range index.OnDump {
+		offsets = append(offsets, offset)
+	}
+
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	for _, offset := range offsets {
+		for _, e := range index.OnDump[offset] {
+			if err := wr.Write([]string{strconv.FormatInt(e.ID, 10), e.Title, strconv.FormatInt(e.Offset, 10)}); err != nil {
+				return fmt.Errorf("failed to write body: %w", err)
+			}
+		}
+	}
+
+	wr.Flush()
+
+	if err := wr.Error(); err != nil {
+		return fmt.Errorf("failed to flush: %w", err)
+	}
+
+	return nil
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
+
+func (o OutputOptions) WriteJSON(w io.Writer, stations []Station) error {
+	records, err := o.records(stations)
+	if err != nil {
+		return err
+	}
+
+	if records == nil {
+		records = []any{}
+	}
+
+	var v any = records
+	if o.Schema {
+		v = struct {
+			SchemaVersion int    `json:"schema_version"`
+			GeneratedFrom string `json:"generated_from"`
+			Stations      []any  `json:"stations"`
+		}{SchemaVersion, o.GeneratedFrom, records}
+	}
+
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+
+	if err := e.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode stations: %w", err)
+	}
+
+	return nil
+}
+
+func (o OutputOptions) WriteNDJSON(w io.Writer, stations []Station) error {
+	records, err := o.records(stations)
+	if err != nil {
+		return err
+	}
+
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+
+	for _, r := range records {
+		if err := e.Encode(r); err != nil {
+			return fmt.Errorf("failed to encode station: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// records returns what the JSON writers encode for stations: the stations
+// themselves by default, or else objects with the selected columns in order.
+func (o OutputOptions) records(stations []Station) ([]any, error) {
+	stations = o.prepare(stations)
+
+	var records []any
+
+	if o.Columns == nil && !o.OmitEmpty && !o.WithID {
+		for _, s := range stations {
+			records = append(records, o.fillMissing(s))
+		}
+
+		return records, nil
+	}
+
+	cs, omit, err := o.jsonColumns()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range stations {
+		records = append(records, record{cs, s, omit})
+	}
+
+	return records, nil
+}
+
+// jsonColumns returns the columns of the JSON objects and which of their
+// values to leave out.
+func (o OutputOptions) jsonColumns() ([]column, func(name string, v any) bool, error) {
+	if o.Columns == nil {
+		// Without a selection, the fields of the struct, left out as its
+		// omitempty would.
+		cs, _ := o.lookupColumns(slices.DeleteFunc(Columns(), func(name string) bool {
+			return name == "source" && !o.Provenance || name == "id" && !o.WithID || name == "raw" && !o.Raw
+		}))
+
+		return cs, func(name string, v any) bool {
+			return v == nil || v == "" && (o.OmitEmpty || omitEmpty[name])
+		}, nil
+	}
+
+	cs, err := o.columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cs, func(name string, v any) bool {
+		return o.OmitEmpty && (v == nil || v == "")
+	}, nil
+}
+
+// WriteGeoJSON writes the stations as a GeoJSON FeatureCollection of points,
+// with the columns but lat and lon as the properties. The stations without
+// coordinates are left out unless NullGeometry.
+func (o OutputOptions) WriteGeoJSON(w io.Writer, stations []Station) error {
+	stations = o.prepare(stations)
+
+	cs, omit, err := o.jsonColumns()
+	if err != nil {
+		return err
+	}
+
+	cs = slices.DeleteFunc(cs, func(c column) bool { return c.name == "lat" || c.name == "lon" })
+
+	type point struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"`
+	}
+
+	type feature struct {
+		Type       string `json:"type"`
+		Geometry   *point `json:"geometry"`
+		Properties record `json:"properties"`
+	}
+
+	features := []feature{}
+
+	for _, s := range stations {
+		f := feature{Type: "Feature", Properties: record{cs, s, omit}}
+		if s.Lat != 0 || s.Lon != 0 {
+			f.Geometry = &point{"Point", [2]float64{s.Lon, s.Lat}}
+		} else if !o.NullGeometry {
+			continue
+		}
+
+		features = append(features, f)
+	}
+
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+
+	err = e.Encode(struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{"FeatureCollection", features})
+	if err != nil {
+		return fmt.Errorf("failed to encode stations: %w", err)
+	}
+
+	return nil
+}
+
+// record encodes the columns of a station as a JSON object in their order.
+type record struct {
+	columns []column
+	station Station
+	omit    func(name string, v any) bool
+}
+
+func (r record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+
+	buf.WriteByte('{')
+
+	n := 0
+	for _, c := range r.columns {
+		v := c.value(r.station)
+		if r.omit(c.name, v) {
+			continue
+		}
+
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		n++
+
+		if err := e.Encode(c.name); err != nil {
+			return nil, err
+		}
+
+		buf.WriteByte(':')
+
+		if err := e.Encode(v); err != nil {
+			return nil, err
+		}
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// prepare clears the fields the options leave out so that omitempty drops
+// them from JSON. Missing is filled in as the values are written instead, so
+// that the id column is derived from the fields as extracted.
+func (o OutputOptions) prepare(stations []Station) []Station {
+	if o.Provenance && o.Raw || o.Columns != nil {
+		return stations
+	}
+
+	ss := make([]Station, len(stations))
+
+	for i, s := range stations {
+		if !o.Provenance {
+			s.Source = ""
+		}
+		if !o.Raw {
+			s.Raw = ""
+		}
+
+		ss[i] = s
+	}
+
+	return ss
+}
+
+// fillMissing returns s with Missing in its empty missingColumns, for the
+// JSON encoding of the struct.
+func (o OutputOptions) fillMissing(s Station) Station {
+	if o.Missing == "" {
+		return s
+	}
+
+	for _, f := range []*string{&s.Name, &s.NameKana, &s.NameEn, &s.Prefecture, &s.Operator, &s.Line} {
+		if *f == "" {
+			*f = o.Missing
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,368 @@
+package stations
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testStations are stations with the fields the writers handle specially set
+// or left empty.
+var testStations = []Station{
+	{Name: "赤羽駅", NameKana: "あかばね", NameEn: "Akabane"},
+	{Name: "我孫子駅", NameKana: "あびこ", NameEn: "Abiko"},
+	{Name: "道後温泉駅", NameKana: "どうごおんせん", NameEn: "Dōgo Onsen \"Station\"\tEhime"},
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteNDJSON(&buf, testStations); err != nil {
+		t.Fatal(err)
+	}
+
+	sc := bufio.NewScanner(&buf)
+
+	var got []Station
+	for sc.Scan() {
+		var s Station
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			t.Fatalf("line %d: %v", len(got)+1, err)
+		}
+		got = append(got, s)
+	}
+
+	if len(got) != len(testStations) {
+		t.Fatalf("got %d lines, want %d", len(got), len(testStations))
+	}
+
+	for i, s := range got {
+		if s != testStations[i] {
+			t.Errorf("line %d: got %+v, want %+v", i+1, s, testStations[i])
+		}
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	ss := []Station{{Name: "赤羽駅", NameKana: "あかばね", NameEn: "Akabane", Source: "List of railway stations in Japan: A"}}
+
+	for _, tt := range []struct {
+		provenance  bool
+		tsv, ndjson string
+	}{
+		{false, "name\tname_kana\tname_en\tprefecture\toperator\tline\n赤羽駅\tあかばね\tAkabane\t\t\t\n", `{"name":"赤羽駅","name_kana":"あかばね","name_en":"Akabane","prefecture":"","operator":"","line":""}` + "\n"},
+		{true, "name\tname_kana\tname_en\tprefecture\toperator\tline\tsource\n赤羽駅\tあかばね\tAkabane\t\t\t\tList of railway stations in Japan: A\n", `{"name":"赤羽駅","name_kana":"あかばね","name_en":"Akabane","prefecture":"","operator":"","line":"","source":"List of railway stations in Japan: A"}` + "\n"},
+	} {
+		o := OutputOptions{Provenance: tt.provenance}
+
+		var tsv, ndjson strings.Builder
+		if err := o.WriteTSV(&tsv, ss); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := o.WriteNDJSON(&ndjson, ss); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := tsv.String(); got != tt.tsv {
+			t.Errorf("provenance %v: got TSV %q, want %q", tt.provenance, got, tt.tsv)
+		}
+
+		if got := ndjson.String(); got != tt.ndjson {
+			t.Errorf("provenance %v: got NDJSON %q, want %q", tt.provenance, got, tt.ndjson)
+		}
+	}
+}
+
+func TestOutputColumns(t *testing.T) {
+	for _, tt := range []struct {
+		columns           []string
+		wantTSV, wantJSON string
+	}{
+		{
+			[]string{"name_en"},
+			"name_en\nAkabane\nAbiko\n",
+			`[{"name_en":"Akabane"},{"name_en":"Abiko"}]` + "\n",
+		},
+		{
+			[]string{"name_kana", "name"},
+			"name_kana\tname\nあかばね\t赤羽駅\nあびこ\t我孫子駅\n",
+			`[{"name_kana":"あかばね","name":"赤羽駅"},{"name_kana":"あびこ","name":"我孫子駅"}]` + "\n",
+		},
+	} {
+		o := OutputOptions{Columns: tt.columns}
+
+		var tsv, js bytes.Buffer
+		if err := o.WriteTSV(&tsv, testStations[:2]); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := o.WriteJSON(&js, testStations[:2]); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := tsv.String(); got != tt.wantTSV {
+			t.Errorf("%q: got TSV %q, want %q", tt.columns, got, tt.wantTSV)
+		}
+
+		if got := js.String(); got != tt.wantJSON {
+			t.Errorf("%q: got JSON %q, want %q", tt.columns, got, tt.wantJSON)
+		}
+	}
+
+	err := OutputOptions{Columns: []string{"name", "kana"}}.WriteTSV(&bytes.Buffer{}, testStations)
+	if err == nil || !strings.Contains(err.Error(), `unknown column "kana" (valid columns: `) || !strings.Contains(err.Error(), "name_kana") {
+		t.Errorf("got %v, want an error naming the valid columns", err)
+	}
+
+	if err := CheckColumns([]string{"name_en", "bogus"}); err == nil {
+		t.Error("got no error for an unknown column")
+	}
+}
+
+func TestOutputMissing(t *testing.T) {
+	abiko := []Station{{Name: "我孫子駅", NameKana: "あびこ", NameEn: "Abiko", Prefecture: "Chiba"}}
+
+	for _, tt := range []struct {
+		name  string
+		o     OutputOptions
+		write func(OutputOptions, *bytes.Buffer) error
+		want  string
+	}{
+		{
+			"TSV", OutputOptions{Missing: "N/A"},
+			func(o OutputOptions, w *bytes.Buffer) error { return o.WriteTSV(w, abiko) },
+			"name\tname_kana\tname_en\tprefecture\toperator\tline\n我孫子駅\tあびこ\tAbiko\tChiba\tN/A\tN/A\n",
+		},
+		{
+			"JSON", OutputOptions{Missing: "N/A"},
+			func(o OutputOptions, w *bytes.Buffer) error { return o.WriteJSON(w, abiko) },
+			`[{"name":"我孫子駅","name_kana":"あびこ","name_en":"Abiko","prefecture":"Chiba","operator":"N/A","line":"N/A"}]` + "\n",
+		},
+		{
+			"JSON without", OutputOptions{},
+			func(o OutputOptions, w *bytes.Buffer) error { return o.WriteJSON(w, abiko) },
+			`[{"name":"我孫子駅","name_kana":"あびこ","name_en":"Abiko","prefecture":"Chiba","operator":"","line":""}]` + "\n",
+		},
+		{
+			"JSON omitempty", OutputOptions{OmitEmpty: true},
+			func(o OutputOptions, w *bytes.Buffer) error { return o.WriteJSON(w, abiko) },
+			`[{"name":"我孫子駅","name_kana":"あびこ","name_en":"Abiko","prefecture":"Chiba"}]` + "\n",
+		},
+		{
+			"NDJSON omitempty", OutputOptions{OmitEmpty: true},
+			func(o OutputOptions, w *bytes.Buffer) error { return o.WriteNDJSON(w, abiko) },
+			`{"name":"我孫子駅","name_kana":"あびこ","name_en":"Abiko","prefecture":"Chiba"}` + "\n",
+		},
+		{
+			"TSV columns", OutputOptions{Missing: "-", Columns: []string{"name_en", "line", "lat"}},
+			func(o OutputOptions, w *bytes.Buffer) error { return o.WriteTSV(w, abiko) },
+			"name_en\tline\tlat\nAbiko\t-\t-\n",
+		},
+	} {
+		var buf bytes.Buffer
+		if err := tt.write(tt.o, &buf); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithID(t *testing.T) {
+	akabane := testStations[:1]
+
+	id := akabane[0].ID()
+	if other := testStations[1].ID(); other == id {
+		t.Errorf("got the same id %d for Akabane and Abiko", id)
+	}
+
+	moved := akabane[0]
+	moved.Lat, moved.Lon, moved.Source = 35.78, 139.72, "List of railway stations in Japan: A"
+	if got := moved.ID(); got != id {
+		t.Errorf("got id %d with coordinates and a source, want %d", got, id)
+	}
+
+	var tsv, js bytes.Buffer
+	if err := (OutputOptions{WithID: true}).WriteTSV(&tsv, akabane); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (OutputOptions{WithID: true}).WriteJSON(&js, akabane); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := fmt.Sprintf("id\tname\tname_kana\tname_en\tprefecture\toperator\tline\n%d\t赤羽駅\tあかばね\tAkabane\t\t\t\n", id); tsv.String() != want {
+		t.Errorf("got TSV %q, want %q", tsv.String(), want)
+	}
+
+	if want := fmt.Sprintf(`[{"id":%d,"name":"赤羽駅","name_kana":"あかばね","name_en":"Akabane","prefecture":"","operator":"","line":""}]`+"\n", id); js.String() != want {
+		t.Errorf("got JSON %q, want %q", js.String(), want)
+	}
+}
+
+func TestWithIDMissing(t *testing.T) {
+	// The id is derived from the fields as extracted, not the placeholders.
+	akabane := testStations[:1]
+	want := strconv.FormatInt(akabane[0].ID(), 10)
+
+	o := OutputOptions{WithID: true, Missing: "N/A", NullGeometry: true}
+	for _, tt := range []struct {
+		name  string
+		write func(io.Writer, []Station) error
+	}{
+		{"TSV", o.WriteTSV},
+		{"JSON", o.WriteJSON},
+		{"GeoJSON", o.WriteGeoJSON},
+	} {
+		var buf bytes.Buffer
+		if err := tt.write(&buf, akabane); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := buf.String(); !strings.Contains(got, want) || !strings.Contains(got, "N/A") {
+			t.Errorf("%s: got %q, want id %s and the placeholders", tt.name, got, want)
+		}
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	o := OutputOptions{Columns: []string{"name", "name_kana", "name_en", "code"}}
+
+	ss := slices.Clone(testStations[:2])
+	ss[0].Code = "JK38"
+
+	var buf bytes.Buffer
+	if err := o.WriteTable(&buf, ss); err != nil {
+		t.Fatal(err)
+	}
+
+	// Kanji and kana take two columns each, and nothing trails the lines.
+	want := "" +
+		"name      name_kana  name_en  code\n" +
+		"赤羽駅    あかばね   Akabane  JK38\n" +
+		"我孫子駅  あびこ     Abiko\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// Every column starts at the same width on every line.
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for _, cell := range []string{"name_en", "Akabane", "Abiko"} {
+		for _, line := range lines {
+			if i := strings.Index(line, cell); i >= 0 && displayWidth(line[:i]) != 21 {
+				t.Errorf("got %q at width %d of %q, want 21", cell, displayWidth(line[:i]), line)
+			}
+		}
+	}
+}
+
+func TestOutputHeaders(t *testing.T) {
+	o := OutputOptions{Columns: []string{"name_en", "name"}, Headers: map[string]string{"name_en": "English", "name": "Japanese", "line": "Line"}}
+
+	var buf bytes.Buffer
+	if err := o.WriteTSV(&buf, testStations[:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := buf.String(), "English\tJapanese\nAkabane\t赤羽駅\nAbiko\t我孫子駅\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	o := OutputOptions{Schema: true, GeneratedFrom: "20210920"}
+
+	var tsv, js, ndjson bytes.Buffer
+	if err := o.WriteTSV(&tsv, testStations[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.WriteJSON(&js, testStations[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.WriteNDJSON(&ndjson, testStations[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := fmt.Sprintf("# schema_version=%d generated_from=20210920\nname\tname_kana\tname_en\tprefecture\toperator\tline\n赤羽駅\tあかばね\tAkabane\t\t\t\n", SchemaVersion); tsv.String() != want {
+		t.Errorf("got TSV %q, want %q", tsv.String(), want)
+	}
+
+	if want := fmt.Sprintf(`{"schema_version":%d,"generated_from":"20210920","stations":[{"name":"赤羽駅","name_kana":"あかばね","name_en":"Akabane","prefecture":"","operator":"","line":""}]}`+"\n", SchemaVersion); js.String() != want {
+		t.Errorf("got JSON %q, want %q", js.String(), want)
+	}
+
+	// NDJSON has no room for the schema version.
+	if want := `{"name":"赤羽駅","name_kana":"あかばね","name_en":"Akabane","prefecture":"","operator":"","line":""}` + "\n"; ndjson.String() != want {
+		t.Errorf("got NDJSON %q, want %q", ndjson.String(), want)
+	}
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	type featureCollection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			Geometry *struct {
+				Type        string    `json:"type"`
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+
+	// Only the first station has coordinates.
+	ss := slices.Clone(testStations)
+	ss[0].Lat, ss[0].Lon = 35.7778, 139.7208
+
+	for _, tt := range []struct {
+		nullGeometry bool
+		features     int
+	}{
+		{false, 1},
+		{true, len(ss)},
+	} {
+		var buf bytes.Buffer
+		if err := (OutputOptions{NullGeometry: tt.nullGeometry}).WriteGeoJSON(&buf, ss); err != nil {
+			t.Fatal(err)
+		}
+
+		var fc featureCollection
+		if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+			t.Fatal(err)
+		}
+
+		if fc.Type != "FeatureCollection" || len(fc.Features) != tt.features {
+			t.Fatalf("NullGeometry %v: got a %s of %d features, want a FeatureCollection of %d", tt.nullGeometry, fc.Type, len(fc.Features), tt.features)
+		}
+
+		// The coordinates are longitude first.
+		f := fc.Features[0]
+		if f.Type != "Feature" || f.Geometry == nil || f.Geometry.Type != "Point" || len(f.Geometry.Coordinates) != 2 || f.Geometry.Coordinates[0] != 139.7208 || f.Geometry.Coordinates[1] != 35.7778 {
+			t.Errorf("NullGeometry %v: got %+v, want a point at [139.7208, 35.7778]", tt.nullGeometry, f)
+		}
+
+		if f.Properties["name_en"] != "Akabane" || f.Properties["name_kana"] != "あかばね" {
+			t.Errorf("NullGeometry %v: got properties %v, want the names", tt.nullGeometry, f.Properties)
+		}
+
+		if _, ok := f.Properties["lat"]; ok {
+			t.Errorf("NullGeometry %v: got lat in the properties", tt.nullGeometry)
+		}
+
+		for _, f := range fc.Features[1:] {
+			if f.Geometry != nil {
+				t.Errorf("NullGeometry %v: got geometry %+v for %v, want null", tt.nullGeometry, f.Geometry, f.Properties["name_en"])
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+
+	write := func(s string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, s)
+			return err
+		}
+	}
+
+	if err := writeFileAtomic(path, 0o644, write("first")); err != nil {
+		t.Fatal(err)
+	}
+
+	errWrite := errors.New("write failed")
+	err := writeFileAtomic(path, 0o644, func(w io.Writer) error {
+		write("second")(w)
+		return errWrite
+	})
+	if !errors.Is(err, errWrite) {
+		t.Fatalf("got %v, want %v", err, errWrite)
+	}
+
+	if b, err := os.ReadFile(path); err != nil || string(b) != "first" {
+		t.Errorf("got %q, %v, want the file as it was before the failed write", b, err)
+	}
+
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o644 {
+		t.Errorf("got mode %v, %v, want %v", fi.Mode().Perm(), err, os.FileMode(0o644))
+	}
+
+	if es, _ := os.ReadDir(dir); len(es) != 1 {
+		t.Errorf("got %d files, want the temporary file removed", len(es))
+	}
+}